@@ -0,0 +1,116 @@
+package pickett
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+const (
+	CACHE_LABEL = "pickett.cache"
+	CACHE_MOUNT = "/pickett-cache"
+)
+
+//cacheNames are the names docker allows for volumes, less the prefix we add.
+var cacheNames = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+//cacheVolume returns the name of the docker volume that holds the go cache of project with
+//the name given in the configuration.  Caches are the project's own, like its containers.
+func cacheVolume(project string, name string) string {
+	if project == "" {
+		return "pickett-cache-" + name
+	}
+	return "pickett-cache-" + project + "-" + name
+}
+
+//addCache mounts a go cache of project into a run of the go tool, creating its volume if
+//this is the first time it is used.  The build cache and the module cache share the volume.
+func addCache(project string, name string, rc *io.RunConfig, cli io.DockerCli) error {
+	if name == "" {
+		return nil
+	}
+	vol := cacheVolume(project, name)
+	labels := map[string]string{CACHE_LABEL: name}
+	if project != "" {
+		labels[io.PROJECT_LABEL] = project
+	}
+	if err := cli.CmdCreateVolume(vol, labels); err != nil {
+		return fmt.Errorf("unable to create cache volume %s: %v", vol, err)
+	}
+	rc.Volumes[vol] = CACHE_MOUNT
+	rc.Env = append(rc.Env, "GOCACHE="+CACHE_MOUNT+"/build", "GOMODCACHE="+CACHE_MOUNT+"/mod")
+	return nil
+}
+
+//projectCaches returns the cache volumes of the project of config, by volume name.
+func projectCaches(config *Config) ([]*io.VolumeInfo, error) {
+	vols, err := config.cli.CmdListVolumes(CACHE_LABEL)
+	if err != nil {
+		return nil, err
+	}
+	result := []*io.VolumeInfo{}
+	for _, v := range vols {
+		if v.Labels[io.PROJECT_LABEL] != config.project {
+			continue
+		}
+		result = append(result, v)
+	}
+	sort.Sort(byVolumeName(result))
+	return result, nil
+}
+
+// CmdCache lists (ls) or removes (prune) the volumes pickett keeps the go caches of this
+// project in.  Prune removes the caches named, or all of them if none are.  The caches of
+// other projects are left alone.
+func CmdCache(action string, names []string, config *Config) error {
+	vols, err := projectCaches(config)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "ls":
+		usedBy := make(map[string][]string)
+		for _, b := range config.GoBuilds {
+			if b.Cache != "" {
+				usedBy[b.Cache] = append(usedBy[b.Cache], b.Repository+":"+b.Tag)
+			}
+		}
+		w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+		fmt.Fprint(w, "CACHE\tVOLUME\tUSED BY\n")
+		for _, v := range vols {
+			name := v.Labels[CACHE_LABEL]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, v.Name, strings.Join(usedBy[name], ", "))
+		}
+		w.Flush()
+	case "prune":
+		failed := []string{}
+		for _, v := range vols {
+			if len(names) > 0 && !contains(names, v.Labels[CACHE_LABEL]) {
+				continue
+			}
+			if err := config.cli.CmdRmVolume(v.Name); err != nil {
+				flog.Errorf("unable to remove %s: %v", v.Name, err)
+				failed = append(failed, v.Name)
+				continue
+			}
+			fmt.Printf("[pickett] removed cache %s (%s)\n", v.Labels[CACHE_LABEL], v.Name)
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("unable to remove %s", strings.Join(failed, ", "))
+		}
+	default:
+		return fmt.Errorf("don't know how to '%s' caches, try ls or prune", action)
+	}
+	return nil
+}
+
+type byVolumeName []*io.VolumeInfo
+
+func (b byVolumeName) Len() int           { return len(b) }
+func (b byVolumeName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byVolumeName) Less(i, j int) bool { return b[i].Name < b[j].Name }
@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/igneous-systems/pickett/io"
 )
@@ -20,79 +21,92 @@ type runVolumeSpec struct {
 // CmdRun is the 'run' entry point of the program with the targets filled in
 // and a working helper.
 func CmdRun(target string, runVol string, config *Config) (int, error) {
-	var vol *runVolumeSpec
-	if runVol != "" {
-		pair := strings.Split(runVol, ":")
-		if len(pair) != 2 {
-			return 1, fmt.Errorf("unable to understand run volume (%s), should be /foo:/bar/foo", runVol)
-		}
-		vol = &runVolumeSpec{pair[0], pair[1]}
+	vol, err := parseRunVolume(runVol)
+	if err != nil {
+		return 1, err
 	}
 	return config.Execute(target, vol)
 }
 
-//return value is a bit tricky here for the primary return.  If it's nil
-//then the entire topology is not known.  If its an empty map, then node is
-//not known but the topology is.  Otherwise, it's a map from integer instance
-//numbers to container names. If the string value is empty, it means that we
-//have seen this instance before but not available at the present time.
-func statusInstances(topoName string, nodeName string, config *Config) (map[int]string, error) {
-	topology, ok := config.nameToTopology[topoName]
-	if !ok {
-		return nil, fmt.Errorf("bad topology name: %s", topoName)
+//parseRunVolume understands the --runvol flag, which may be empty.
+func parseRunVolume(runVol string) (*runVolumeSpec, error) {
+	if runVol == "" {
+		return nil, nil
 	}
-	_, ok = topology[nodeName]
-	if !ok {
-		return nil, fmt.Errorf("bad topology entry: %s", nodeName)
+	pair := strings.Split(runVol, ":")
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("unable to understand run volume (%s), should be /foo:/bar/foo", runVol)
 	}
+	return &runVolumeSpec{pair[0], pair[1]}, nil
+}
 
-	contPath := filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS)
-	topos, found, err := config.etcd.Children(contPath)
-	if !found {
-		return nil, nil //nothing found at this level
+// CmdWatch runs target and then watches the source of the images it runs in.  When the
+// source changes, the images that are out of date are rebuilt and the policy of each
+// runner decides what happens to its containers.  It only returns if watching fails.
+func CmdWatch(target string, runVol string, quiet time.Duration, config *Config) error {
+	vol, err := parseRunVolume(runVol)
+	if err != nil {
+		return err
 	}
+	runners, err := config.watchRunners(target)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if !contains(topos, topoName) {
-		return nil, nil
+	nodes := watchNodes(runners)
+	dirs := config.watchDirs(nodes)
+	if len(dirs) == 0 {
+		return fmt.Errorf("nothing to watch, %s does not run in any image built from source", target)
 	}
-	result := make(map[int]string)
+	w, err := config.helper.Watch(dirs)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
 
-	nodePath := filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS, topoName)
-	nodes, found, err := config.etcd.Children(nodePath)
-	if !found {
-		return result, nil
+	if err := config.refresh(target, vol); err != nil {
+		flog.Errorf("%s: %v", target, err)
 	}
-	if err != nil {
-		return nil, fmt.Errorf("%v, maybe you've never run anything before?", err)
+	fmt.Printf("[pickett] watching %s for changes to %s\n", target, strings.Join(dirs, ", "))
+	for {
+		changed, ok := debounce(w.Changes(), quiet)
+		if !ok {
+			return fmt.Errorf("stopped watching for changes to %s", target)
+		}
+		affected := config.affectedNodes(nodes, changed)
+		if len(affected) == 0 {
+			flog.Debugf("ignoring changes to %v, nothing is built from them", changed)
+			continue
+		}
+		names := []string{}
+		for _, n := range affected {
+			n.forget()
+			names = append(names, n.name())
+		}
+		fmt.Printf("[pickett] source of %s changed, updating %s\n", strings.Join(names, ", "), target)
+		if err := config.refresh(target, vol); err != nil {
+			flog.Errorf("%s: %v", target, err)
+		}
 	}
-	if !contains(nodes, nodeName) {
-		return result, nil
+}
+
+//statusInstances returns a map from integer instance numbers to container names for every
+//instance of the node that has a record.  It is an error if the topology or node is not known.
+func statusInstances(topoName string, nodeName string, config *Config) (map[int]string, error) {
+	topology, ok := config.nameToTopology[topoName]
+	if !ok {
+		return nil, fmt.Errorf("bad topology name: %s", topoName)
 	}
-	instPath := filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS, topoName, nodeName)
-	instances, found, err := config.etcd.Children(instPath)
-	if !found {
-		return result, nil
+	_, ok = topology[nodeName]
+	if !ok {
+		return nil, fmt.Errorf("bad topology entry: %s", nodeName)
 	}
+	records, err := recordedInstances(config.etcd, topoName, nodeName)
 	if err != nil {
 		return nil, err
 	}
-	for _, inst := range instances {
-		x, err := strconv.ParseInt(inst, 10, 32)
-		if err != nil {
-			return nil, err
-		}
-		i := int(x)
-		cont, found, err := config.etcd.Get(filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS, topoName, nodeName, inst))
-		if err != nil {
-			return nil, err
-		}
-		if found {
-			result[i] = cont
-		} else {
-			result[i] = ""
-		}
+	result := make(map[int]string)
+	for i, rec := range records {
+		result[i] = rec.ContainerName
 	}
 	return result, nil
 }
@@ -123,13 +137,23 @@ func CmdBuild(targets []string, config *Config) error {
 	return nil
 }
 
+//chosenRunnables returns the topology nodes selected by targets in the order they should be
+//started.  A target is either topo.node or the name of a topology, which selects all of its
+//nodes.  No targets at all selects every node in every topology.
 func chosenRunnables(config *Config, targets []string) []string {
 	_, runnables := config.EntryPoints()
 	if len(targets) == 0 {
-		return runnables
+		for t := range config.nameToTopology {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
 	}
 	run := []string{}
 	for _, targ := range targets {
+		if _, ok := config.nameToTopology[targ]; ok {
+			run = append(run, config.topologyTargets(targ)...)
+			continue
+		}
 		if contains(runnables, targ) {
 			run = append(run, targ)
 		}
@@ -137,6 +161,16 @@ func chosenRunnables(config *Config, targets []string) []string {
 	return run
 }
 
+//reversed returns a copy of s in reverse order, for tearing things down in the opposite
+//order from the one they were started in.
+func reversed(s []string) []string {
+	result := make([]string, len(s))
+	for i, v := range s {
+		result[len(s)-1-i] = v
+	}
+	return result
+}
+
 // CmdStatus shows the status of all known targets or the set you supply
 func CmdStatus(targets []string, config *Config) error {
 	runStatus := chosenRunnables(config, targets)
@@ -178,9 +212,10 @@ func CmdStatus(targets []string, config *Config) error {
 			fmt.Printf("%-25s | %-31s\n", target, "not found")
 			continue
 		}
+		cli := config.cliOf(config.nameToTopology[pair[0]][pair[1]].runner)
 		for i, cont := range instances {
 			extra := fmt.Sprintf("[%d]", i)
-			insp, err := config.cli.InspectContainer(cont)
+			insp, err := cli.InspectContainer(cont)
 			if err != nil {
 				fmt.Printf("container %s not inspected: %v\n", cont, err)
 				continue
@@ -194,9 +229,10 @@ func CmdStatus(targets []string, config *Config) error {
 	return nil
 }
 
-// CmdStop stops the targets containers
-func CmdStop(targets []string, config *Config) error {
-	stopSet := chosenRunnables(config, targets)
+// CmdStop stops the targets containers, consumers before the nodes they consume.  A
+// negative timeout means use the timeout configured for each node.
+func CmdStop(targets []string, timeout int, config *Config) error {
+	stopSet := reversed(chosenRunnables(config, targets))
 	for _, stop := range stopSet {
 		pair := strings.Split(stop, ".")
 		if len(pair) != 2 {
@@ -206,15 +242,17 @@ func CmdStop(targets []string, config *Config) error {
 		if err != nil {
 			return err
 		}
+		r := config.nameToTopology[pair[0]][pair[1]].runner
+		cli := config.cliOf(r)
 		for _, contId := range instances {
-			insp, err := config.cli.InspectContainer(contId)
+			insp, err := cli.InspectContainer(contId)
 			if err != nil {
 				flog.Errorf("Failed to inspect %s, already destroyed ? - %s", contId, err)
 				continue // This can happen, so we should not error out.
 			}
 			if insp.Running() {
 				fmt.Printf("[pickett] trying to stop %s [%s]\n", contId, stop)
-				if err := config.cli.CmdStop(contId); err != nil {
+				if err := stopContainer(r, contId, timeout, cli); err != nil {
 					return err
 				}
 			}
@@ -223,13 +261,13 @@ func CmdStop(targets []string, config *Config) error {
 	return nil
 }
 
-// CmdDrop stops and removes the targets containers
-func CmdDrop(targets []string, config *Config) error {
-	err := CmdStop(targets, config)
+// CmdDrop stops and removes the targets containers, consumers before the nodes they consume.
+func CmdDrop(targets []string, timeout int, config *Config) error {
+	err := CmdStop(targets, timeout, config)
 	if err != nil {
 		return err
 	}
-	dropSet := chosenRunnables(config, targets)
+	dropSet := reversed(chosenRunnables(config, targets))
 	for _, drop := range dropSet {
 		pair := strings.Split(drop, ".")
 		if len(pair) != 2 {
@@ -239,18 +277,14 @@ func CmdDrop(targets []string, config *Config) error {
 		if err != nil {
 			return err
 		}
+		cli := config.cliOf(config.nameToTopology[pair[0]][pair[1]].runner)
 		for i, contId := range instances {
-			if err := config.cli.CmdRmContainer(contId); err != nil {
+			if err := cli.CmdRmContainer(contId); err != nil {
 				flog.Errorf("Failed to remove %s, already destroyed ? - %s", contId, err)
 				continue // This can happen, so we should not error out.
 			}
-			key := filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS, pair[0], pair[1], fmt.Sprint(i))
-			oldId, err := config.etcd.Del(key)
-			if err != nil || oldId != contId {
-				if err != nil {
-					return err
-				}
-				return fmt.Errorf("Unexpected container id: expecting %s but got %s!", contId, oldId)
+			if err := deleteRecord(config.etcd, pair[0], pair[1], i); err != nil {
+				return err
 			}
 		}
 	}
@@ -304,8 +338,9 @@ func CmdPs(targets []string, config *Config) error {
 			return err
 		}
 
+		cli := config.cliOf(config.nameToTopology[pair[0]][pair[1]].runner)
 		for i, contId := range instances {
-			insp, err := config.cli.InspectContainer(contId)
+			insp, err := cli.InspectContainer(contId)
 			if err != nil {
 				return err
 			}
@@ -318,21 +353,87 @@ func CmdPs(targets []string, config *Config) error {
 	return nil
 }
 
+// CmdContinue shows the committed state of the targets that use the CONTINUE policy, or
+// throws it away if reset is true.
+func CmdContinue(targets []string, reset bool, config *Config) error {
+	selected := chosenRunnables(config, targets)
+	w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+	if !reset {
+		fmt.Fprint(w, "TARGET\tIMAGE\tFROM CONTAINER\tCOMMITTED\n")
+	}
+	for _, target := range selected {
+		pair := strings.Split(target, ".")
+		if len(pair) != 2 {
+			panic(fmt.Sprintf("can't understand the target %s", target))
+		}
+		info := config.nameToTopology[pair[0]][pair[1]]
+		if info.runner.(*topoRunner).policy.start != CONTINUE {
+			if len(targets) != 0 {
+				flog.Warningf("%s does not use the CONTINUE policy, ignoring", target)
+			}
+			continue
+		}
+		for i := 0; i < info.instances; i++ {
+			if reset {
+				fmt.Printf("[pickett] resetting %s.%d\n", target, i)
+				if err := resetContinuation(info.runner, pair[0], i, "", config); err != nil {
+					return err
+				}
+				continue
+			}
+			lineage, err := loadContinuation(info.runner, pair[0], i, config)
+			if err != nil {
+				return err
+			}
+			if lineage == nil {
+				continue
+			}
+			fmt.Fprintf(w, "%s.%v\t%s\t%s\t%s\n", target, i, lineage.Base, "", "")
+			for _, img := range lineage.Images {
+				fmt.Fprintf(w, "\t%s\t%s\t%s\n", shortID(img.ID), img.Container, img.Committed.Format(TIME_FORMAT))
+			}
+		}
+	}
+	w.Flush()
+	return nil
+}
+
+//shortID abbreviates a docker id the way docker does.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
 func CmdInject(target string, cmds []string, config *Config) error {
 
-	breakout := strings.Replace(target, ".", "/", -1)
-	// NOTE TO SELF: write a tree-ish function that returns an enumeration/array of topo nodes
-	cont, found, err := config.etcd.Get(filepath.Join(io.PICKETT_KEYSPACE, CONTAINERS, breakout))
+	parts := strings.Split(target, ".")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("can't understand the target %s, should be topology.node[.instance]", target)
+	}
+	instance := 0
+	if len(parts) == 3 {
+		x, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return fmt.Errorf("bad instance number in %s: %v", target, err)
+		}
+		instance = x
+	}
+	rec, found, err := loadRecord(config.etcd, parts[0], parts[1], instance)
 	if err != nil {
 		return err
 	} else if !found {
 		return fmt.Errorf("No instance information found in etcd, is `%v' running?", target)
 	}
-
-	strings.TrimPrefix(cont, "/")
+	cont := strings.TrimPrefix(rec.ContainerName, "/")
 
 	fmt.Printf("Inspecting %v\n", cont)
-	insp, err := config.cli.InspectContainer(cont)
+	info, ok := config.nameToTopology[parts[0]][parts[1]]
+	if !ok {
+		return fmt.Errorf("no such topology node: %s.%s", parts[0], parts[1])
+	}
+	insp, err := config.cliOf(info.runner).InspectContainer(cont)
 	if err != nil {
 		return err
 	}
@@ -382,13 +483,120 @@ func confTargets(config *Config) []string {
 	return all
 }
 
-// CmdDestroy stops and removes all containers, and removes all images
-func CmdDestroy(config *Config) error {
-	const Up = "Up"
+// CmdDestroy stops and removes the containers of this project, removes the images built
+// for it and wipes what pickett knows about it in etcd.  Other projects, and things pickett
+// did not create, are left alone unless allProjects is set, which destroys every container,
+// image and etcd key there is; the caller is expected to have made sure that is wanted.  The
+// state in etcd is only wiped once the containers are gone.
+func CmdDestroy(allProjects bool, config *Config) error {
+	if allProjects {
+		return destroyAll(config)
+	}
+	if config.project == "" {
+		return fmt.Errorf("no project to destroy")
+	}
+	fmt.Printf("[pickett] destroying project %s\n", config.project)
+
+	//the state goes last, so the containers can still be found if removing them fails
+	for _, cli := range config.dockerClis() {
+		if err := destroyProject(config.project, cli); err != nil {
+			return err
+		}
+	}
+
+	etcd := unscoped(config.etcd)
+	if _, found, err := etcd.Children(projectKeyspace(config.project)); err != nil {
+		return err
+	} else if found {
+		if _, err := etcd.RecursiveDel(projectKeyspace(config.project)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//destroyProject stops and removes the containers of project on the docker host of cli, and
+//removes the images built for it there.
+func destroyProject(project string, cli io.DockerCli) error {
+	containers, err := cli.ListProjectContainers(project)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, c := range containers {
+		//never trust the docker to have filtered on the label
+		if c.Labels[io.PROJECT_LABEL] != project {
+			flog.Debugf("not removing container %s, it is not part of %s", c.ID, project)
+			continue
+		}
+		if c.Running() {
+			if err := cli.CmdStop(c.ID, nil); err != nil {
+				return err
+			}
+		}
+		if err := cli.CmdRmContainer(c.ID); err != nil {
+			return err
+		}
+		removed++
+	}
+	fmt.Printf("[pickett] removed %d containers\n", removed)
+
+	images, err := cli.ListProjectImages(project)
+	if err != nil {
+		return err
+	}
+	//untag everything first, then remove the images themselves; an image can't be removed
+	//before the ones built on it, so keep going while that makes progress
+	pending := []string{}
+	for _, img := range images {
+		if img.Labels[io.PROJECT_LABEL] != project {
+			flog.Debugf("not removing image %s, it is not part of %s", img.ID, project)
+			continue
+		}
+		for _, tag := range img.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+			if err := cli.CmdRmImage(tag); err != nil {
+				flog.Debugf("not removing %s: %v", tag, err)
+			}
+		}
+		pending = append(pending, img.ID)
+	}
+	removed = 0
+	for len(pending) > 0 {
+		left := []string{}
+		for _, id := range pending {
+			if err := cli.CmdRmImage(id); err != nil {
+				flog.Debugf("not removing %s yet: %v", id, err)
+				left = append(left, id)
+				continue
+			}
+			removed++
+		}
+		if len(left) == len(pending) {
+			break
+		}
+		pending = left
+	}
+	fmt.Printf("[pickett] removed %d images\n", removed)
+	return nil
+}
+
+//destroyAll stops and removes all containers, removes all images and wipes etcd, whoever
+//they belong to, on every docker host.
+func destroyAll(config *Config) error {
+	for _, cli := range config.dockerClis() {
+		if err := destroyEverything(cli); err != nil {
+			return err
+		}
+	}
 
 	fmt.Println("clearing etcd")
 
-	resps, found, err := config.etcd.Children("/")
+	//all of etcd, not just what the project sees
+	etcd := unscoped(config.etcd)
+	resps, found, err := etcd.Children("/")
 	if err != nil {
 		return err
 	}
@@ -397,15 +605,23 @@ func CmdDestroy(config *Config) error {
 	}
 
 	for _, resp := range resps {
-		_, err := config.etcd.RecursiveDel("/" + resp)
+		_, err := etcd.RecursiveDel("/" + resp)
 		if err != nil {
 			return err
 		}
 	}
 
+	return nil
+}
+
+//destroyEverything stops and removes all containers and removes all images on the docker
+//host of cli.
+func destroyEverything(cli io.DockerCli) error {
+	const Up = "Up"
+
 	fmt.Println("stopping running containers")
 
-	containers, err := config.cli.ListContainers()
+	containers, err := cli.ListContainers()
 	if err != nil {
 		return err
 	}
@@ -413,7 +629,7 @@ func CmdDestroy(config *Config) error {
 	for _, container := range containers {
 		status := strings.Split(container.Status, " ")
 		if status[0] == Up {
-			err = config.cli.CmdStop(container.ID)
+			err = cli.CmdStop(container.ID, nil)
 			if err != nil {
 				return err
 			}
@@ -423,7 +639,7 @@ func CmdDestroy(config *Config) error {
 	fmt.Println("removing containers")
 
 	for _, container := range containers {
-		err = config.cli.CmdRmContainer(container.ID)
+		err = cli.CmdRmContainer(container.ID)
 		if err != nil {
 			return err
 		}
@@ -431,17 +647,16 @@ func CmdDestroy(config *Config) error {
 
 	fmt.Println("removing images")
 
-	images, err := config.cli.ListImages()
+	images, err := cli.ListImages()
 	if err != nil {
 		return err
 	}
 
 	for _, image := range images {
-		err = config.cli.CmdRmImage(image.ID)
+		err = cli.CmdRmImage(image.ID)
 		if err != nil {
 			flog.Debugf(err.Error())
 		}
 	}
-
 	return nil
 }
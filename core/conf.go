@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	pickett_io "github.com/igneous-systems/pickett/io"
 )
@@ -30,7 +32,24 @@ type GoBuild struct {
 	Tag        string
 	Packages   []string
 	TestFile   string
-	Probe      string
+	PerPackage bool
+	Cache      string
+	Module     string //directory with go.mod, Packages are relative to it
+	Vendor     bool
+	Flags      []string
+	Tags       []string
+	GOOS       string
+	GOARCH     string
+	LDFlags    string
+	Test       *GoTest
+	Platforms  []string //like linux/amd64, each tagged Tag-os-arch, Test runs once, for RunIn
+}
+
+//GoTest runs "go test" on the packages of a GoBuild after they are built.  Report is the
+//path, relative to this file, of a JUnit XML report to write.
+type GoTest struct {
+	Flags  []string
+	Report string
 }
 
 type GenericBuild struct {
@@ -40,8 +59,12 @@ type GenericBuild struct {
 }
 
 type Artifact struct {
-	BuiltPath      string
+	BuiltPath      string //can be a pattern, like /go/bin/*
 	DestinationDir string
+	Rename         string   //new name for the one thing BuiltPath names
+	Mode           string   //octal, like 0755, for the files
+	Owner          string   //uid:gid, numeric
+	Exclude        []string //patterns for names (or paths, with a /) to leave out
 }
 
 type Extraction struct {
@@ -50,24 +73,31 @@ type Extraction struct {
 	MergeWith  string
 	Tag        string
 	Artifacts  []*Artifact
+	ExportTo   string //a directory or a .tar, .tar.gz or .tgz file, relative to this file
 }
 
 type TopologyEntry struct {
-	Name       string
-	RunIn      string
-	EntryPoint []string
-	Consumes   []string
-	Policy     string
-	Expose     map[string]int
-	Instances  int
-	Devices    map[string]string
-	Privileged bool
-	WaitFor    bool
+	Name        string
+	RunIn       string
+	EntryPoint  []string
+	Consumes    []string
+	Policy      string
+	Expose      map[string]int
+	Instances   int
+	Devices     map[string]string
+	Privileged  bool
+	WaitFor     bool
+	StopTimeout *int //seconds, DEFAULT_STOP_TIMEOUT if not given
+	StopSignal  string
+	PreStop     []string
+	Host        string `json:",omitempty"` //one of the Hosts, the default docker host if empty
 }
 
 type BuildOpts struct {
 	DontUseCache    bool
 	RemoveContainer bool
+	Quiet           bool //only show the output of builds that fail
+	Keep            int  //how many previous images of each tag to keep, as repo:tag-1 and so on
 }
 
 type topoInfo struct {
@@ -76,6 +106,7 @@ type topoInfo struct {
 }
 
 type Config struct {
+	Project            string //keeps our state apart from other projects, see SetProject
 	DockerBuildOptions BuildOpts
 	CodeVolumes        []*CodeVolume
 	Containers         []*Container
@@ -83,6 +114,8 @@ type Config struct {
 	Extractions        []*Extraction
 	GenericBuilds      []*GenericBuild
 	Topologies         map[string][]*TopologyEntry
+	Hosts              map[string]*HostEntry
+	PathMappings       []*PathMapping //where the docker daemon finds directories of this machine
 
 	//internal objects
 	nameToNode     map[string]node
@@ -90,6 +123,14 @@ type Config struct {
 	helper         pickett_io.Helper
 	cli            pickett_io.DockerCli
 	etcd           pickett_io.EtcdClient
+	buildLock      sync.Mutex
+	timings        buildTimings
+	project        string
+	hosts          map[string]*dockerHost
+	mappings       map[string][]*PathMapping //by host, "" is the default one
+	vagrantOnce    sync.Once
+	vagrant        []*PathMapping
+	vagrantErr     error
 }
 
 type topoMap map[string]*topoInfo
@@ -105,7 +146,7 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	lines := strings.Split(string(all), "\n")
 	var noComments bytes.Buffer
 	for _, line := range lines {
-		if index := strings.Index(line, "//"); index != -1 {
+		if index := commentStart(line); index != -1 {
 			if index == 0 {
 				continue
 			}
@@ -133,6 +174,10 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	conf.nameToNode = make(map[string]node)
 	conf.nameToTopology = make(map[string]topoMap)
 
+	if err := conf.checkPathMappings(); err != nil {
+		return nil, err
+	}
+
 	// PART 1: containers cannot reference anything other than containers,
 	// PART 1: so we can just process them
 	if err := conf.checkContainerNodes(); err != nil {
@@ -157,6 +202,11 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 		}
 		topos[t] = impl
 	}
+	for t := range topos {
+		if _, err := conf.topologyOrder(t); err != nil {
+			return nil, err
+		}
+	}
 	extractImpl, err := conf.checkExtractionNodes()
 	if err != nil {
 		return nil, err
@@ -179,6 +229,23 @@ func NewConfig(reader io.Reader, helper pickett_io.Helper, cli pickett_io.Docker
 	return conf, nil
 }
 
+//commentStart returns where the comment on line starts, or -1 if there is none.  A // in
+//a string (like the one in an endpoint, tcp://host:port) does not start a comment.
+func commentStart(line string) int {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch {
+		case inString && line[i] == '\\':
+			i++
+		case line[i] == '"':
+			inString = !inString
+		case !inString && strings.HasPrefix(line[i:], "//"):
+			return i
+		}
+	}
+	return -1
+}
+
 // EntryPoints returns two lists, the list of buildable targets and the list of runnable
 // topologies.
 func (c *Config) EntryPoints() ([]string, []string) {
@@ -212,9 +279,13 @@ func (c *Config) Build(name string) error {
 	return node.build(c)
 }
 
-// Execute is called by the "main()" of the pickett program to run a "target".
+// Execute is called by the "main()" of the pickett program to run a "target".  The
+// target is either a single node (foo.bar) or a whole topology (foo).
 func (c *Config) Execute(name string, vol *runVolumeSpec) (int, error) {
 	pair := strings.Split(strings.Trim(name, " \n"), ".")
+	if len(pair) == 1 {
+		return c.executeTopology(pair[0], vol)
+	}
 	if len(pair) != 2 {
 		return 1, fmt.Errorf("unable to understand '%s', expect something like 'foo.bar'", name)
 	}
@@ -243,11 +314,9 @@ func (c *Config) Execute(name string, vol *runVolumeSpec) (int, error) {
 			return 1, err
 		}
 		if wait {
-			insp, err := c.cli.InspectContainer(p.containerName)
-			if err != nil {
+			if exitStatus, err = c.exitStatus(info.runner, p); err != nil {
 				return 1, err
 			}
-			exitStatus = insp.ExitStatus()
 		}
 	}
 	return exitStatus, nil
@@ -257,15 +326,29 @@ func (c *Config) Execute(name string, vol *runVolumeSpec) (int, error) {
 func (c *Config) codeVolumes() (map[string]string, error) {
 	results := make(map[string]string)
 	for _, v := range c.CodeVolumes {
-		dir := c.helper.DirectoryRelative(v.Directory)
-		var err error
-		if needsPathTranslation() {
-			dir, err = translatePath(dir)
-			if err != nil {
-				return nil, err
-			}
+		dir, err := c.daemonPath("", c.helper.DirectoryRelative(v.Directory))
+		if err != nil {
+			return nil, err
 		}
 		results[dir] = v.MountedAt
 	}
 	return results, nil
 }
+
+// hostPath returns where a path inside a container that mounts the code volumes is found
+// on this machine.  It is false if the path is not in a code volume.
+func (c *Config) hostPath(containerPath string) (string, bool) {
+	best := -1
+	result := ""
+	for _, v := range c.CodeVolumes {
+		mount := strings.TrimRight(v.MountedAt, "/")
+		if containerPath != mount && !strings.HasPrefix(containerPath, mount+"/") {
+			continue
+		}
+		if len(mount) > best {
+			best = len(mount)
+			result = filepath.Join(c.helper.DirectoryRelative(v.Directory), containerPath[len(mount):])
+		}
+	}
+	return result, best >= 0
+}
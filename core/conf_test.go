@@ -42,6 +42,7 @@ var example1 = `
 			"Repository": "fart",
 			"RunIn" : "blah:bletch",
 			"Packages": ["p4...", "p5/p6" ],
+			"PerPackage": true,
 			"Tag": "chattanooga"
 		}
 	]
@@ -70,3 +71,17 @@ func TestConf(t *testing.T) {
 		t.Errorf("failed to parse CodeVolume>Directory")
 	}
 }
+
+func TestCommentStart(t *testing.T) {
+	for line, expected := range map[string]int{
+		`// a comment`:                                0,
+		`	"RunIn": "image", // a comment`:             19,
+		`"Endpoint": "tcp://10.0.0.1:2375"`:           -1,
+		`"Endpoint": "tcp://host:2375" // the "back"`: 30,
+		`"Escaped": "\"//\""`:                         -1,
+	} {
+		if got := commentStart(line); got != expected {
+			t.Errorf("comment of %q starts at %d, expected %d", line, got, expected)
+		}
+	}
+}
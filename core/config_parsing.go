@@ -1,7 +1,10 @@
 package pickett
 
 import (
+	"crypto/sha1"
+	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	pickett_io "github.com/igneous-systems/pickett/io"
@@ -79,9 +82,40 @@ func (c *Config) checkGoBuildNodes() (map[*goBuilder]string, error) {
 		if err := c.checkExistingNodeName(build.Tag); err != nil {
 			return nil, err
 		}
-		node := newNodeImpl(w)
-		c.nameToNode[w.tag()] = node
-		implementations[w] = strings.Trim(build.RunIn, " \n")
+		if len(build.Platforms) == 0 {
+			c.nameToNode[w.tag()] = newNodeImpl(w)
+			implementations[w] = strings.Trim(build.RunIn, " \n")
+			continue
+		}
+		if build.GOOS != "" || build.GOARCH != "" {
+			return nil, fmt.Errorf("%s can't have both Platforms and GOOS/GOARCH", w.tag())
+		}
+		group := &platformsBuilder{repository: w.repository, tagname: w.tagname}
+		for i, platform := range build.Platforms {
+			goos, goarch, err := parsePlatform(platform)
+			if err != nil {
+				return nil, fmt.Errorf("bad platform for %s: %v", w.tag(), err)
+			}
+			p := *w
+			p.goos, p.goarch = goos, goarch
+			p.tagname = platformTag(w.tagname, goos, goarch)
+			//the tests run for the platform of the container, once is enough
+			if i > 0 {
+				p.test = nil
+			}
+			if err := c.checkExistingNodeName(p.tag()); err != nil {
+				return nil, err
+			}
+			node := newNodeImpl(&p)
+			c.nameToNode[p.tag()] = node
+			implementations[&p] = strings.Trim(build.RunIn, " \n")
+			group.platforms = append(group.platforms, node)
+		}
+		groupNode := newNodeImpl(group)
+		for _, p := range group.platforms {
+			p.addOut(groupNode)
+		}
+		c.nameToNode[group.tag()] = groupNode
 	}
 	return implementations, nil
 }
@@ -133,8 +167,8 @@ func (c *Config) checkExtractionNodes() (map[*extractionBuilder][]string, error)
 
 		mergeTrimmed := strings.Trim(build.MergeWith, " \n")
 		inTrimmed := strings.Trim(build.RunIn, " \n")
-		if mergeTrimmed == "" || inTrimmed == "" {
-			return nil, fmt.Errorf("MergeWith and RunIn are required for extractions!")
+		if inTrimmed == "" || (mergeTrimmed == "" && w.exportTo == "") {
+			return nil, fmt.Errorf("MergeWith (or ExportTo) and RunIn are required for extractions!")
 		}
 		// the order of this append matters!
 		implementations[w] = append(implementations[w], inTrimmed, mergeTrimmed)
@@ -162,7 +196,13 @@ func (c *Config) dependenciesExtractNodes(implementations map[*extractionBuilder
 		}
 		extract.runIn = n
 
-		//incoming from mergeWith
+		//incoming from mergeWith, unless we only export
+		if merge == "" {
+			if extract.runIn.isNode {
+				r.addOut(c.nameToNode[extract.tag()])
+			}
+			continue
+		}
 		if !c.tagExists(merge, c.cli) {
 			return fmt.Errorf("Unable to find '%s' (MergeWith) in extract build '%s': maybe you need to 'docker pull' it?",
 				merge, extract.tag())
@@ -287,13 +327,40 @@ func (c *Config) newTopoRunner(n *TopologyEntry) (*topoRunner, error) {
 		exp[key] = append(curr, b)
 	}
 
+	if _, ok := c.Hosts[n.Host]; n.Host != "" && !ok {
+		return nil, fmt.Errorf("%s is on host %s, which is not one of the Hosts", n.Name, n.Host)
+	}
+	if err := pickett_io.ValidateSignal(n.StopSignal); err != nil {
+		return nil, fmt.Errorf("bad StopSignal for %s: %v", n.Name, err)
+	}
+	stop := pickett_io.StopConfig{
+		Timeout: pickett_io.DEFAULT_STOP_TIMEOUT,
+		Signal:  n.StopSignal,
+	}
+	if n.StopTimeout != nil {
+		if *n.StopTimeout < 0 {
+			return nil, fmt.Errorf("bad StopTimeout for %s: %d", n.Name, *n.StopTimeout)
+		}
+		stop.Timeout = uint(*n.StopTimeout)
+	}
+
 	result := &topoRunner{
-		n:      n.Name,
-		expose: exp,
-		devs:   n.Devices,
-		priv:   n.Privileged,
-		wait:   n.WaitFor,
+		n:       n.Name,
+		expose:  exp,
+		devs:    n.Devices,
+		priv:    n.Privileged,
+		wait:    n.WaitFor,
+		stop:    stop,
+		preStop: n.PreStop,
+		onHost:  n.Host,
 	}
+	//the hash lets us tell later whether a running instance was started with this config
+	buf, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	result.hash = fmt.Sprintf("%x", sha1.Sum(buf))
+
 	pol := defaultPolicy()
 	switch strings.ToUpper(n.Policy) {
 	case "BY_HAND":
@@ -362,10 +429,34 @@ func (c *Config) newGoBuilder(build *GoBuild) (*goBuilder, error) {
 	if build.TestFile != "" {
 		result.testFile = build.TestFile
 	}
-	if build.Probe != "" {
-		result.probe = build.Probe
-	} else {
-		result.probe = "go install -n"
+	result.perPackage = build.PerPackage
+	if build.Cache != "" && !cacheNames.MatchString(build.Cache) {
+		return nil, fmt.Errorf("bad Cache name '%s' for %s, use letters, digits, '_', '.' and '-'", build.Cache, result.tag())
+	}
+	result.cache = build.Cache
+	if build.Module != "" {
+		f, err := c.helper.OpenFileRelative(filepath.Join(build.Module, "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("Module of %s must be a directory with a go.mod: %v", result.tag(), err)
+		}
+		if f != nil {
+			f.Close()
+		}
+	} else if build.Vendor {
+		return nil, fmt.Errorf("Vendor is only for go builds with a Module (%s)", result.tag())
+	}
+	result.module = build.Module
+	result.vendor = build.Vendor
+	result.flags = build.Flags
+	result.tags = build.Tags
+	result.goos = build.GOOS
+	result.goarch = build.GOARCH
+	result.ldflags = build.LDFlags
+	if build.Test != nil {
+		if build.TestFile != "" {
+			return nil, fmt.Errorf("%s can't have both a Test section and a TestFile", result.tag())
+		}
+		result.test = &goTester{flags: build.Test.Flags, report: build.Test.Report}
 	}
 	return result, nil
 }
@@ -379,8 +470,12 @@ func (c *Config) newExtractionBuilder(build *Extraction) (*extractionBuilder, er
 	}
 	worker := &extractionBuilder{
 		artifacts:  build.Artifacts,
+		exportTo:   strings.Trim(build.ExportTo, "\n "),
 		tagname:    strings.Trim(build.Tag, "\n "),
 		repository: strings.Trim(build.Repository, "\n "),
 	}
+	if _, err := worker.toCopyArtifacts(); err != nil {
+		return nil, fmt.Errorf("%s: %v", worker.tag(), err)
+	}
 	return worker, nil
 }
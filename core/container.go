@@ -87,6 +87,8 @@ func (d *containerBuilder) build(config *Config) (time.Time, error) {
 	opts := &io.BuildConfig{
 		NoCache:                  config.DockerBuildOptions.DontUseCache,
 		RemoveTemporaryContainer: config.DockerBuildOptions.RemoveContainer,
+		Quiet:                    config.DockerBuildOptions.Quiet,
+		Project:                  config.project,
 	}
 	dirName := config.helper.DirectoryRelative(d.dir)
 	flog.Infof("Building tarball in %s", d.dir)
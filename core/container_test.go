@@ -1,6 +1,7 @@
 package pickett
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -51,10 +52,12 @@ func TestAfterBuildTimeIsUpdated(t *testing.T) {
 
 	//hook inspecteds to calls to Inspect in ORDER
 	first := cli.EXPECT().InspectImage(BLETCH).Return(hourStamp, nil)
-	cli.EXPECT().InspectImage(BLETCH).Return(nowStamp, nil).After(first)
+	second := cli.EXPECT().InspectImage(BLETCH).Return(nowStamp, nil).After(first)
 
 	//get this after the first time check comparing directry time to hourStamp
+	expectLock(etcd, "/pickett/locks/builds/"+BLETCH)
 	cli.EXPECT().CmdBuild(gomock.Any(), DIR, BLETCH).Return(nil)
+	expectHistory(controller, helper, cli, etcd, BLETCH, SOMEID, second)
 
 	///
 	//at start, we don't know antyhing about the time
@@ -73,3 +76,35 @@ func TestAfterBuildTimeIsUpdated(t *testing.T) {
 	}
 
 }
+
+func TestQuietBuildReportsFailedStep(t *testing.T) {
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	helper.EXPECT().OpenDockerfileRelative(MYDIR).Return(nil, nil)
+	helper.EXPECT().DirectoryRelative(MYDIR).Return(DIR)
+
+	c, _ := NewConfig(strings.NewReader(example1), helper, cli, etcd)
+	c.DockerBuildOptions.Quiet = true
+
+	//never built
+	helper.EXPECT().LastTimeInDirRelative(MYDIR).Return(time.Now(), nil)
+	cli.EXPECT().InspectImage(BLETCH).Return(nil, fmt.Errorf("no such image"))
+	expectLock(etcd, "/pickett/locks/builds/"+BLETCH)
+	failure := &io.BuildError{Tag: BLETCH, Step: "Step 2 : RUN make", Message: "returned a non-zero code: 2"}
+	cli.EXPECT().CmdBuild(gomock.Any(), DIR, BLETCH).Do(func(opts *io.BuildConfig, dir string, tag string) {
+		if !opts.Quiet {
+			t.Errorf("expected a quiet build")
+		}
+	}).Return(failure)
+
+	err := c.Build(BLETCH)
+	if err == nil || !strings.Contains(err.Error(), "Step 2 : RUN make") {
+		t.Fatalf("expected the failed step in the error, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//continuation is the lineage of a node instance run with the CONTINUE policy.  Each time the
+//instance is restarted its container is committed and the new container is started from
+//the committed image, so Images is the sequence of states it has passed through.  Base is
+//the image that the first container in the sequence was started from.
+type continuation struct {
+	Base   string
+	Images []*continuedImage
+}
+
+type continuedImage struct {
+	ID        string
+	Container string
+	Committed time.Time
+}
+
+//latest returns the most recent image in the lineage, or "" if there is none.
+func (c *continuation) latest() string {
+	if len(c.Images) == 0 {
+		return ""
+	}
+	return c.Images[len(c.Images)-1].ID
+}
+
+//continueTag returns where the committed state of an instance is tagged.  The repository
+//is the one the runner's image came from with -continue added.
+func continueTag(r runner, topoName string, instance int) *io.TagInfo {
+	repo := r.imageName()
+	//a colon after the last slash is a tag, before it is a registry port
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return &io.TagInfo{
+		Repository: repo + "-continue",
+		Tag:        fmt.Sprintf("%s-%s-%d", topoName, strings.Trim(r.name(), " \n"), instance),
+	}
+}
+
+//loadContinuation reads the lineage of an instance from etcd.  It returns nil if there is
+//none.
+func loadContinuation(r runner, topoName string, instance int, conf *Config) (*continuation, error) {
+	value, present, err := conf.etcd.Get(formKey(CONTINUES, r, topoName, instance))
+	if err != nil || !present {
+		return nil, err
+	}
+	result := &continuation{}
+	if err := json.Unmarshal([]byte(value), result); err != nil {
+		return nil, fmt.Errorf("can't understand continuation of %s.%s: %v", topoName, r.name(), err)
+	}
+	return result, nil
+}
+
+//commitContinuation commits the (stopped) container of an instance to its continue tag,
+//adds the result to the lineage and returns the image id to continue from.  The committed
+//container is removed since its state now lives in the image.
+func commitContinuation(in *policyInput, topoName string, instance int, conf *Config) (string, error) {
+	lineage, err := loadContinuation(in.r, topoName, instance, conf)
+	if err != nil {
+		return "", err
+	}
+	if lineage == nil {
+		lineage = &continuation{Base: in.r.imageName()}
+	}
+	img, err := conf.cliOf(in.r).CmdCommit(in.containerName, continueTag(in.r, topoName, instance))
+	if err != nil {
+		return "", err
+	}
+	lineage.Images = append(lineage.Images, &continuedImage{
+		ID:        img,
+		Container: in.containerName,
+		Committed: time.Now(),
+	})
+	buf, err := json.Marshal(lineage)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conf.etcd.Put(formKey(CONTINUES, in.r, topoName, instance), string(buf)); err != nil {
+		return "", err
+	}
+	if err := conf.cliOf(in.r).CmdRmContainer(in.containerName); err != nil {
+		flog.Warningf("unable to remove container %s after committing it: %v", in.containerName, err)
+	}
+	return img, nil
+}
+
+//resetContinuation throws away the accumulated state of an instance: the continue image,
+//the lineage and the container, if it is stopped.  The next start is from the runner's image.
+//stopped is a container of the instance that is no longer recorded, like one the policy has
+//just stopped, to remove as well; it is "" if there is none.
+func resetContinuation(r runner, topoName string, instance int, stopped string, conf *Config) error {
+	rec, present, err := loadRecord(conf.etcd, topoName, r.name(), instance)
+	if err != nil {
+		return err
+	}
+	conts := []string{}
+	if present {
+		conts = append(conts, rec.ContainerName)
+	}
+	if stopped != "" && (!present || stopped != rec.ContainerName) {
+		conts = append(conts, stopped)
+	}
+	for _, cont := range conts {
+		insp, err := conf.cliOf(r).InspectContainer(cont)
+		if err == nil && insp.Running() {
+			return fmt.Errorf("%s.%s[%d] is running (%s), stop it before resetting", topoName, r.name(), instance, cont)
+		}
+		if err == nil {
+			if err := conf.cliOf(r).CmdRmContainer(cont); err != nil {
+				return err
+			}
+		}
+	}
+	if present {
+		if err := deleteRecord(conf.etcd, topoName, r.name(), instance); err != nil {
+			return err
+		}
+	}
+
+	lineage, err := loadContinuation(r, topoName, instance, conf)
+	if err != nil || lineage == nil {
+		return err
+	}
+	tag := continueTag(r, topoName, instance)
+	if err := conf.cliOf(r).CmdRmImage(tag.Repository + ":" + tag.Tag); err != nil && err.Error() != "no such image" {
+		return err
+	}
+	_, err = conf.etcd.Del(formKey(CONTINUES, r, topoName, instance))
+	return err
+}
@@ -0,0 +1,192 @@
+package pickett
+
+import (
+	"errors"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestDestroyOnlyTouchesTheProject(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{cli: cli, etcd: etcd}
+	if err := c.SetProject("mine-01234567", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	//a docker that ignores the filter returns what is not ours too
+	mine := map[string]string{io.PROJECT_LABEL: "mine-01234567"}
+	cli.EXPECT().ListProjectContainers("mine-01234567").Return([]*io.ContainerInfo{
+		{ID: "running", Status: "Up 2 hours", Labels: mine},
+		{ID: "stopped", Status: "Exited (0) 1 hours ago", Labels: mine},
+		{ID: "users", Status: "Up 2 hours"},
+		{ID: "theirs", Status: "Exited (0) 1 hours ago", Labels: map[string]string{io.PROJECT_LABEL: "theirs-76543210"}},
+	}, nil)
+	cli.EXPECT().CmdStop("running", nil).Return(nil)
+	cli.EXPECT().CmdRmContainer("running").Return(nil)
+	cli.EXPECT().CmdRmContainer("stopped").Return(nil)
+
+	//the base can only go once the image built on it is gone
+	cli.EXPECT().ListProjectImages("mine-01234567").Return([]*io.ImageInfo{
+		{ID: "base", RepoTags: []string{BLETCH}, Labels: mine},
+		{ID: "child", RepoTags: []string{"<none>:<none>"}, Labels: mine},
+		{ID: "ubuntu", RepoTags: []string{"ubuntu:latest"}},
+	}, nil)
+	cli.EXPECT().CmdRmImage(BLETCH).Return(nil)
+	first := cli.EXPECT().CmdRmImage("base").Return(errors.New("conflict"))
+	cli.EXPECT().CmdRmImage("child").Return(nil)
+	last := cli.EXPECT().CmdRmImage("base").Return(nil).After(first)
+
+	//then all that is known about the project goes
+	etcd.EXPECT().Children("/pickett/projects/mine-01234567").Return([]string{"instances", "builds"}, true, nil).After(last)
+	etcd.EXPECT().RecursiveDel("/pickett/projects/mine-01234567").Return("", nil)
+
+	if err := CmdDestroy(false, c); err != nil {
+		t.Errorf("unexpected error destroying: %v", err)
+	}
+}
+
+func TestDestroyKeepsStateOfContainersLeft(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	c := &Config{cli: cli, etcd: io.NewMockEtcdClient(controller)}
+	if err := c.SetProject("mine-01234567", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	//nothing is removed from etcd when the containers can't be
+	cli.EXPECT().ListProjectContainers("mine-01234567").Return([]*io.ContainerInfo{
+		{ID: "running", Status: "Up 2 hours", Labels: map[string]string{io.PROJECT_LABEL: "mine-01234567"}},
+	}, nil)
+	cli.EXPECT().CmdStop("running", nil).Return(errors.New("no stopping it"))
+
+	if err := CmdDestroy(false, c); err == nil {
+		t.Errorf("expected an error when a container can't be stopped")
+	}
+}
+
+func TestDestroyAllProjects(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	remote := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{
+		cli:   cli,
+		etcd:  etcd,
+		Hosts: map[string]*HostEntry{"remote": {}},
+		hosts: map[string]*dockerHost{"remote": {cli: remote}},
+	}
+	if err := c.SetProject("mine-01234567", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	//every host is cleared, then all of etcd, not just the project
+	var last *gomock.Call
+	for _, d := range []*io.MockDockerCli{cli, remote} {
+		d.EXPECT().ListContainers().Return(nil, nil)
+		last = d.EXPECT().ListImages().Return(nil, nil)
+	}
+	etcd.EXPECT().Children("/").Return([]string{"pickett"}, true, nil).After(last)
+	etcd.EXPECT().RecursiveDel("/pickett").Return("", nil)
+
+	if err := CmdDestroy(true, c); err != nil {
+		t.Errorf("unexpected error destroying everything: %v", err)
+	}
+}
+
+func TestProjectKeyspace(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{etcd: etcd, Project: "fromconfig"}
+	if err := c.SetProject("", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	//the project's own keyspace, other keys are left alone
+	etcd.EXPECT().Get("/pickett/projects/fromconfig/builds/blah:bletch").Return("", false, nil)
+	etcd.EXPECT().Children("/pickett/projects/fromconfig").Return(nil, false, nil)
+	etcd.EXPECT().Get("/other/key").Return("", false, nil)
+	c.etcd.Get(buildRecordKey(BLETCH))
+	c.etcd.Children(io.PICKETT_KEYSPACE)
+	c.etcd.Get("/other/key")
+
+	//another project, given on the command line, replaces it
+	if err := c.SetProject("other", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+	etcd.EXPECT().Put("/pickett/projects/other/instances/dev/db/0", "{}").Return("", nil)
+	c.etcd.Put(recordKey("dev", "db", 0), "{}")
+
+	if err := c.SetProject("no/slashes", ""); err == nil {
+		t.Errorf("expected an error for a bad project")
+	}
+}
+
+func TestProjectID(t *testing.T) {
+	a := ProjectID("/home/me/My Project/Pickett.json")
+	b := ProjectID("/home/me/other/My Project/Pickett.json")
+	if a == b {
+		t.Errorf("two checkouts have the same project: %s", a)
+	}
+	if len(a) != len("my-project-")+8 || a[:len("my-project-")] != "my-project-" {
+		t.Errorf("unexpected project ID %s", a)
+	}
+}
+
+func TestAdoptLegacyState(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{
+		etcd:           etcd,
+		nameToNode:     map[string]node{BLETCH: nil},
+		nameToTopology: map[string]topoMap{"dev": {"db": nil}},
+	}
+	if err := c.SetProject("mine", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	etcd.EXPECT().Get("/pickett/projects/mine/adopted").Return("", false, nil)
+	etcd.EXPECT().Children("/pickett").Return([]string{"instances", "builds", "history", "projects"}, true, nil)
+
+	//the instance moves into the project
+	etcd.EXPECT().Children("/pickett/instances/dev/db").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Children("/pickett/instances/dev/db/0").Return([]string{}, true, nil)
+	etcd.EXPECT().Get("/pickett/instances/dev/db/0").Return(`{"ContainerName":"db0"}`, true, nil)
+	etcd.EXPECT().Get("/pickett/projects/mine/instances/dev/db/0").Return("", false, nil)
+	etcd.EXPECT().Put("/pickett/projects/mine/instances/dev/db/0", `{"ContainerName":"db0"}`).Return("", nil)
+	etcd.EXPECT().Del("/pickett/instances/dev/db/0").Return("", nil)
+	etcd.EXPECT().RecursiveDel("/pickett/instances/dev/db").Return("", nil)
+
+	//the project already has a newer build record, the old one is dropped
+	etcd.EXPECT().Children("/pickett/builds/"+BLETCH).Return([]string{}, true, nil)
+	etcd.EXPECT().Get("/pickett/builds/"+BLETCH).Return("old", true, nil)
+	etcd.EXPECT().Get("/pickett/projects/mine/builds/"+BLETCH).Return("new", true, nil)
+	etcd.EXPECT().Del("/pickett/builds/"+BLETCH).Return("", nil)
+
+	etcd.EXPECT().Children("/pickett/history/"+BLETCH).Return(nil, false, nil)
+	etcd.EXPECT().Put("/pickett/projects/mine/adopted", "true").Return("", nil)
+
+	if err := c.AdoptLegacyState(); err != nil {
+		t.Fatalf("unexpected error adopting: %v", err)
+	}
+
+	//only once
+	etcd.EXPECT().Get("/pickett/projects/mine/adopted").Return("true", true, nil)
+	if err := c.AdoptLegacyState(); err != nil {
+		t.Fatalf("unexpected error adopting again: %v", err)
+	}
+}
@@ -1,8 +1,14 @@
 package pickett
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,15 +25,42 @@ type extractionBuilder struct {
 	runIn      nodeOrName
 	mergeWith  nodeOrName
 	artifacts  []*Artifact
+	exportTo   string
 }
 
 func (e *extractionBuilder) tag() string {
 	return e.repository + ":" + e.tagname
 }
 
-// IsOutOfDate returns true if the tag that we are trying to produce is
-// before the tag of the image we depend on.
+// ood is true if the image or the export, whichever we produce, is out of date.  When we
+// produce both the time is the image's.
 func (e *extractionBuilder) ood(conf *Config) (time.Time, bool, error) {
+	t := time.Time{}
+	if e.mergeWith.name != "" {
+		var ood bool
+		var err error
+		if t, ood, err = e.imageOOD(conf); err != nil || ood {
+			return t, ood, err
+		}
+	}
+	if e.exportTo == "" {
+		return t, false, nil
+	}
+	exported, ood, err := e.exportOOD(conf)
+	if err != nil || ood {
+		return time.Time{}, ood, err
+	}
+	if t.IsZero() {
+		t = exported
+	}
+	return t, false, nil
+}
+
+// imageOOD decides from the labels of our image, without starting a container: we are out
+// of date if the runIn or mergeWith image is not the one we were built from, the artifacts
+// are not configured the same, or an artifact in the source tree has changed.  What the
+// artifacts in the runIn image are can't change without its ID changing.
+func (e *extractionBuilder) imageOOD(conf *Config) (time.Time, bool, error) {
 	t, err := tagToTime(e.tag(), conf.cli)
 	if err != nil {
 		return time.Time{}, true, err
@@ -36,66 +69,96 @@ func (e *extractionBuilder) ood(conf *Config) (time.Time, bool, error) {
 		flog.Infof("Building %s (tag not found)", e.tag())
 		return time.Time{}, true, nil
 	}
-	if e.runIn.isNode && t.Before(e.runIn.node.time()) {
-		flog.Infof("Building %s (out of date with respect to %s)", e.tag(), e.runIn.name)
-		return time.Time{}, true, nil
-	}
-	if e.mergeWith.isNode && t.Before(e.mergeWith.node.time()) {
-		flog.Infof("Building %s (out of date with respect to %s)", e.tag(), e.mergeWith.name)
-		return time.Time{}, true, nil
+	labels, err := conf.cli.ImageLabels(e.tag())
+	if err != nil {
+		return time.Time{}, true, err
 	}
-
-	//get the last change to a source  artifact
-	last, sources, err := e.getSourceExtractions(conf)
+	expected, err := e.labels(conf)
 	if err != nil {
 		return time.Time{}, true, err
 	}
+	for _, label := range []string{EXTRACT_SOURCE_LABEL, EXTRACT_MERGE_LABEL, EXTRACT_SETTINGS_LABEL} {
+		if labels[label] != expected[label] {
+			flog.Infof("Building %s (%s is not the same)", e.tag(), label)
+			return time.Time{}, true, nil
+		}
+	}
 
-	if t.Before(last) {
-		flog.Infof("Building %s (out of date with respect to source artifact)", e.tag())
+	recorded := make(map[string]string)
+	if err := json.Unmarshal([]byte(labels[io.ARTIFACTS_LABEL]), &recorded); err != nil {
+		flog.Infof("Building %s (no artifact digests)", e.tag())
 		return time.Time{}, true, nil
 	}
-
-	art, err := e.toCopyArtifacts()
+	sources, err := e.sourceDigests(conf)
 	if err != nil {
 		return time.Time{}, true, err
 	}
+	for built, digest := range sources {
+		if recorded[built] != digest {
+			flog.Infof("Building %s (out of date with respect to source artifact %s)", e.tag(), built)
+			return time.Time{}, true, nil
+		}
+	}
+
+	flog.Infof("'%s' is up to date", e.tag())
+	return t, false, nil
+}
 
-	//
-	// XXX It's not clear that this code is ever called.  To get this code to be called, you would
-	// XXX have to have a container e.runIn.name that has an OLDER modification time than something
-	// XXX that inside it.  In the normal case, where the tag time of container is at least as old
-	// XXX as any artifact inside it, then the code above that checks for the timestamp of the runIn
-	// XXX node would be invoked and we would not reach here.
-	// XXX
-	// XXX This code has been written for two "strange" cases that may never really occur.  One is when
-	// XXX you have caching turned to make container build faster. This prevents the tag time from
-	// XXX getting updated so perhaps it's possible you could have things inside the container that
-	// XXX that are "newer" than the tag time.  Further, it might be possible that you built the
-	// XXX underlying image on a different machine, with a different clock, than the one you are running
-	// XXX on, and thus tagging on.  In this latter case it's clearly possible to get a tag time that
-	// XXX is older than contents in the "inside" of the container.  What's not clear is whether or not
-	// XXX you have ANY hope of running successfully in a situation this broken.
-
-	inContLast, err := conf.cli.CmdLastModTime(sources, e.runIn.name, art)
+const (
+	EXTRACT_SOURCE_LABEL   = "pickett.extract.source"   //ID of the runIn image
+	EXTRACT_MERGE_LABEL    = "pickett.extract.merge"    //ID of the mergeWith image
+	EXTRACT_SETTINGS_LABEL = "pickett.extract.settings" //digest of the artifact configuration
+)
+
+//labels returns the labels our image should have, other than the artifact digests.
+func (e *extractionBuilder) labels(conf *Config) (map[string]string, error) {
+	result := make(map[string]string)
+	for label, name := range map[string]string{EXTRACT_SOURCE_LABEL: e.runIn.name, EXTRACT_MERGE_LABEL: e.mergeWith.name} {
+		insp, err := conf.cli.InspectImage(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed trying to inspect (%s): %v", name, err)
+		}
+		result[label] = insp.ID()
+	}
+	buf, err := json.Marshal(e.artifacts)
 	if err != nil {
-		return time.Time{}, true, err
+		return nil, err
 	}
+	result[EXTRACT_SETTINGS_LABEL] = fmt.Sprintf("%x", sha1.Sum(buf))
+	return result, nil
+}
 
-	if t.Before(inContLast) {
-		fmt.Printf("[pickett] Building %s (out of date with respect to container artifact)\n", e.tag())
-		return time.Time{}, true, nil
+//sourceDigests returns the digest of each artifact that is in the source tree, by
+//BuiltPath.
+func (e *extractionBuilder) sourceDigests(conf *Config) (map[string]string, error) {
+	sources, err := e.getSourceExtractions(conf)
+	if err != nil {
+		return nil, err
 	}
-
-	flog.Infof("'%s' is up to date", e.tag())
-	return t, false, nil
+	art, err := e.toCopyArtifacts()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, a := range art {
+		hostPath, ok := sources[a.SourcePath]
+		if !ok {
+			continue
+		}
+		if result[a.SourcePath], err = conf.helper.DigestArtifact(hostPath, a); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
 }
 
 //This function is here to walk around on the known artifacts looking for ones that happen to be "inside"
 //the source directories.  Things that are have to handled specially by various parts of the extraction.
-func (e *extractionBuilder) getSourceExtractions(conf *Config) (time.Time, map[string]string, error) {
+func (e *extractionBuilder) getSourceExtractions(conf *Config) (map[string]string, error) {
 
-	//note that this is NOT path translated for the virtual machine!!
+	//these are paths on this machine, not where the docker daemon finds them (see daemonPath):
+	//what is found is read and digested here, straight from the source tree.  Matching only
+	//looks at where the volumes are mounted in the container, which mapping does not change.
 	volumes := make(map[string]string)
 	for _, cv := range conf.CodeVolumes {
 		dir := conf.helper.DirectoryRelative(cv.Directory)
@@ -116,24 +179,14 @@ func (e *extractionBuilder) getSourceExtractions(conf *Config) (time.Time, map[s
 				sourcePath := k + candidateIn[len(mountPoint):]
 				realPathSource[a.BuiltPath] = sourcePath
 			}
-			if strings.HasPrefix(candidateOut, mountPoint) {
-				return time.Time{}, nil, fmt.Errorf("should not be copying things into the source directories for extraction: %s",
+			//exported artifacts don't go into the image
+			if e.mergeWith.name != "" && strings.HasPrefix(candidateOut, mountPoint) {
+				return nil, fmt.Errorf("should not be copying things into the source directories for extraction: %s",
 					a.DestinationDir)
 			}
 		}
 	}
-	best := time.Time{}
-	//test each true source dir for latest time
-	for _, p := range realPathSource {
-		t, err := conf.helper.LastTimeInDir(p)
-		if err != nil {
-			return time.Time{}, nil, err
-		}
-		if t.After(best) {
-			best = t
-		}
-	}
-	return best, realPathSource, nil
+	return realPathSource, nil
 }
 
 func (e *extractionBuilder) toCopyArtifacts() ([]*io.CopyArtifact, error) {
@@ -142,9 +195,44 @@ func (e *extractionBuilder) toCopyArtifacts() ([]*io.CopyArtifact, error) {
 		if len(a.DestinationDir) == 0 || len(a.BuiltPath) == 0 {
 			return art, fmt.Errorf("An artifact must have a DestinationDir & a BuildPath defined !")
 		}
+		if !strings.HasPrefix(a.BuiltPath, "/") {
+			return art, fmt.Errorf("BuiltPath %s of an artifact must be an absolute path", a.BuiltPath)
+		}
+		if strings.Contains(a.Rename, "/") {
+			return art, fmt.Errorf("Rename %s of artifact %s must be a name, not a path", a.Rename, a.BuiltPath)
+		}
+		for _, ex := range a.Exclude {
+			if _, err := path.Match(ex, ""); err != nil {
+				return art, fmt.Errorf("bad Exclude pattern %s for artifact %s: %v", ex, a.BuiltPath, err)
+			}
+		}
+		if _, err := path.Match(a.BuiltPath, ""); err != nil {
+			return art, fmt.Errorf("bad BuiltPath pattern %s: %v", a.BuiltPath, err)
+		}
 		cp := &io.CopyArtifact{
 			SourcePath:     a.BuiltPath,
 			DestinationDir: a.DestinationDir,
+			Rename:         a.Rename,
+			Exclude:        a.Exclude,
+		}
+		if a.Mode != "" {
+			mode, err := strconv.ParseInt(a.Mode, 8, 32)
+			if err != nil || mode&^0777 != 0 {
+				return art, fmt.Errorf("Mode %s of artifact %s must be octal permissions, like 0755", a.Mode, a.BuiltPath)
+			}
+			cp.Mode = mode
+		}
+		if a.Owner != "" {
+			ids := strings.Split(a.Owner, ":")
+			var uidErr, gidErr error
+			if len(ids) == 2 {
+				cp.Uid, uidErr = strconv.Atoi(ids[0])
+				cp.Gid, gidErr = strconv.Atoi(ids[1])
+			}
+			if len(ids) != 2 || uidErr != nil || gidErr != nil {
+				return art, fmt.Errorf("Owner %s of artifact %s must be uid:gid, like 1000:1000", a.Owner, a.BuiltPath)
+			}
+			cp.Owner = true
 		}
 		art = append(art, cp)
 	}
@@ -157,7 +245,7 @@ func (e *extractionBuilder) build(conf *Config) (time.Time, error) {
 
 	var err error
 
-	_, realPathSource, err := e.getSourceExtractions(conf)
+	realPathSource, err := e.getSourceExtractions(conf)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -167,16 +255,127 @@ func (e *extractionBuilder) build(conf *Config) (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	err = conf.cli.CmdCopy(realPathSource, e.runIn.name, e.mergeWith.name, art, e.tag())
+	result := time.Now()
+	if e.mergeWith.name != "" {
+		labels, err := e.labels(conf)
+		if err != nil {
+			return time.Time{}, err
+		}
+		opts := &io.BuildConfig{
+			NoCache:                  true,
+			RemoveTemporaryContainer: true,
+			Quiet:                    conf.DockerBuildOptions.Quiet,
+			Project:                  conf.project,
+		}
+		err = conf.cli.CmdCopy(opts, realPathSource, e.runIn.name, e.mergeWith.name, art, e.tag(), labels)
+		if err != nil {
+			return time.Time{}, err
+		}
+		insp, err := conf.cli.InspectImage(e.tag())
+		if err != nil {
+			return time.Time{}, err
+		}
+		flog.Debugf("done copying, time for %s is %v", e.tag(), insp.CreatedTime())
+		result = insp.CreatedTime()
+	}
+	if e.exportTo != "" {
+		if err := e.export(conf, realPathSource, art); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return result, nil
+}
+
+//exportIsTarball is true if we export to a tarball rather than a directory.  The name
+//says which, and whether it's compressed.
+func (e *extractionBuilder) exportIsTarball() (bool, bool) {
+	for _, suffix := range []string{".tar.gz", ".tgz"} {
+		if strings.HasSuffix(e.exportTo, suffix) {
+			return true, true
+		}
+	}
+	return strings.HasSuffix(e.exportTo, ".tar"), false
+}
+
+//exportOOD checks the export record: we are out of date if the image we export from is not
+//the one we exported from last time, an artifact in the source tree changed since or the
+//export is gone.  None of this needs a container.
+func (e *extractionBuilder) exportOOD(conf *Config) (time.Time, bool, error) {
+	rec, err := loadExportRecord(e.tag(), conf.etcd)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, true, err
+	}
+	if rec == nil || rec.Destination != e.exportTo {
+		flog.Infof("Exporting %s to %s (not exported yet)", e.tag(), e.exportTo)
+		return time.Time{}, true, nil
+	}
+	insp, err := conf.cli.InspectImage(e.runIn.name)
+	if err != nil || insp.ID() != rec.ImageID {
+		flog.Infof("Exporting %s to %s (out of date with respect to %s)", e.tag(), e.exportTo, e.runIn.name)
+		return time.Time{}, true, nil
 	}
-	insp, err := conf.cli.InspectImage(e.tag())
+	sources, err := e.sourceDigests(conf)
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, true, err
+	}
+	if changed := changedDigest(rec.Sources, sources); changed != "" {
+		flog.Infof("Exporting %s to %s (out of date with respect to source artifact %s)", e.tag(), e.exportTo, changed)
+		return time.Time{}, true, nil
+	}
+	f, err := conf.helper.OpenFileRelative(e.exportTo)
+	if err != nil {
+		flog.Infof("Exporting %s to %s (not found)", e.tag(), e.exportTo)
+		return time.Time{}, true, nil
+	}
+	if f != nil {
+		f.Close()
+	}
+	return rec.Exported, false, nil
+}
+
+//export writes the artifacts to the export directory or tarball and records what it
+//exported from.
+func (e *extractionBuilder) export(conf *Config, realPathSource map[string]string, art []*io.CopyArtifact) error {
+	insp, err := conf.cli.InspectImage(e.runIn.name)
+	if err != nil {
+		return fmt.Errorf("failed trying to inspect (%s): %v", e.runIn.name, err)
+	}
+	buf := new(bytes.Buffer)
+	if err := conf.cli.CmdExport(realPathSource, e.runIn.name, art, buf); err != nil {
+		return err
+	}
+	tarball, compressed := e.exportIsTarball()
+	switch {
+	case tarball:
+		content := buf.Bytes()
+		if compressed {
+			zipped := new(bytes.Buffer)
+			zw := gzip.NewWriter(zipped)
+			if _, err := zw.Write(content); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			content = zipped.Bytes()
+		}
+		if err := conf.helper.WriteFileRelative(e.exportTo, content); err != nil {
+			return err
+		}
+		fmt.Printf("[pickett] exported %s to %s\n", e.tag(), e.exportTo)
+	default:
+		n, err := conf.helper.UnpackRelative(e.exportTo, buf)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("[pickett] exported %s to %s (%d files changed)\n", e.tag(), e.exportTo, n)
+	}
+	sources, err := e.sourceDigests(conf)
+	if err != nil {
+		return err
 	}
-	flog.Debugf("done copying, time for %s is %v", e.tag(), insp.CreatedTime())
-	return insp.CreatedTime(), nil
+	rec := &exportRecord{ImageID: insp.ID(), Destination: e.exportTo, Sources: sources, Exported: time.Now()}
+	return rec.save(e.tag(), conf.etcd)
 }
 
 //in returns the inbound edges.  This is not as simple as it would appear
@@ -0,0 +1,202 @@
+package pickett
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var exportExample = `
+{
+	"Containers" : [
+		{
+			"Repository": "blah",
+			"Tag" : "bletch",
+			"Directory" : "mydir"
+		}
+	],
+	"Extractions" : [
+		{
+			"Repository": "dist",
+			"Tag" : "binaries",
+			"RunIn" : "blah:bletch",
+			"ExportTo" : "out/bin",
+			"Artifacts" : [
+				{
+					"BuiltPath" : "/go/bin/server",
+					"DestinationDir" : "linux"
+				}
+			]
+		}
+	]
+}
+`
+
+func TestExportOnlyExtraction(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	helper.EXPECT().OpenDockerfileRelative(MYDIR).Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(exportExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	e := c.nameToNode["dist:binaries"].implementation().(*extractionBuilder)
+
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().ID().Return("bletchid").AnyTimes()
+	cli.EXPECT().InspectImage(BLETCH).Return(insp, nil).AnyTimes()
+
+	//never exported
+	key := "/pickett/exports/dist:binaries"
+	etcd.EXPECT().Get(key).Return("", false, nil)
+	if _, ood, err := e.ood(c); err != nil || !ood {
+		t.Fatalf("expected an extraction that was never exported to be out of date (%v)", err)
+	}
+
+	art := []*io.CopyArtifact{{SourcePath: "/go/bin/server", DestinationDir: "linux"}}
+	cli.EXPECT().CmdExport(map[string]string{}, BLETCH, art, gomock.Any()).Return(nil)
+	helper.EXPECT().UnpackRelative("out/bin", gomock.Any()).Return(1, nil)
+	var saved string
+	etcd.EXPECT().Put(key, gomock.Any()).Do(func(k string, value string) {
+		saved = value
+	}).Return("", nil)
+	if _, err := e.build(c); err != nil {
+		t.Fatalf("unexpected error exporting: %v", err)
+	}
+	rec := &exportRecord{}
+	if err := json.Unmarshal([]byte(saved), rec); err != nil || rec.ImageID != "bletchid" || rec.Destination != "out/bin" {
+		t.Errorf("wrong export record: %s", saved)
+	}
+
+	//nothing changed, so nothing to do and no container needed
+	etcd.EXPECT().Get(key).Return(saved, true, nil)
+	helper.EXPECT().OpenFileRelative("out/bin").Return(nil, nil)
+	if _, ood, err := e.ood(c); err != nil || ood {
+		t.Errorf("expected an unchanged export to be up to date (%v)", err)
+	}
+
+	noMerge := strings.Replace(exportExample, `"ExportTo" : "out/bin",`, "", 1)
+	helper.EXPECT().OpenDockerfileRelative(MYDIR).Return(nil, nil)
+	if _, err := NewConfig(strings.NewReader(noMerge), helper, cli, etcd); err == nil {
+		t.Errorf("expected an error from an extraction with neither MergeWith nor ExportTo")
+	}
+}
+
+func TestArtifactSettings(t *testing.T) {
+	e := &extractionBuilder{artifacts: []*Artifact{{BuiltPath: "/go/bin/*", DestinationDir: "/usr/local/bin",
+		Mode: "0755", Owner: "1000:100", Exclude: []string{"*.debug"}}}}
+	art, err := e.toCopyArtifacts()
+	if err != nil {
+		t.Fatalf("unexpected error from legal artifact: %v", err)
+	}
+	if a := art[0]; a.Mode != 0755 || !a.Owner || a.Uid != 1000 || a.Gid != 100 || a.Exclude[0] != "*.debug" {
+		t.Errorf("wrong artifact settings: %+v", a)
+	}
+	for _, bad := range []*Artifact{
+		{BuiltPath: "/go/bin/x", DestinationDir: "/bin", Mode: "rwx"},
+		{BuiltPath: "/go/bin/x", DestinationDir: "/bin", Owner: "root"},
+		{BuiltPath: "/go/bin/x", DestinationDir: "/bin", Rename: "a/b"},
+		{BuiltPath: "go/bin/x", DestinationDir: "/bin"},
+		{BuiltPath: "/go/bin/[", DestinationDir: "/bin"},
+	} {
+		e.artifacts = []*Artifact{bad}
+		if _, err := e.toCopyArtifacts(); err == nil {
+			t.Errorf("expected an error from artifact %+v", bad)
+		}
+	}
+}
+
+var mergeExample = `
+{
+	"CodeVolumes" : [
+		{
+			"Directory" : "src",
+			"MountedAt" : "/han"
+		}
+	],
+	"Containers" : [
+		{
+			"Repository": "blah",
+			"Tag" : "bletch",
+			"Directory" : "mydir"
+		}
+	],
+	"Extractions" : [
+		{
+			"Repository": "dist",
+			"Tag" : "server",
+			"RunIn" : "blah:bletch",
+			"MergeWith" : "blah:bletch",
+			"Artifacts" : [
+				{
+					"BuiltPath" : "/han/config.json",
+					"DestinationDir" : "/etc"
+				},
+				{
+					"BuiltPath" : "/go/bin/server",
+					"DestinationDir" : "/usr/bin"
+				}
+			]
+		}
+	]
+}
+`
+
+func TestExtractionOODFromLabels(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	helper.EXPECT().OpenDockerfileRelative(MYDIR).Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(mergeExample), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	e := c.nameToNode["dist:server"].implementation().(*extractionBuilder)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+
+	bletch := io.NewMockInspectedImage(controller)
+	bletch.EXPECT().ID().Return("bletchid").AnyTimes()
+	cli.EXPECT().InspectImage(BLETCH).Return(bletch, nil).AnyTimes()
+	server := io.NewMockInspectedImage(controller)
+	server.EXPECT().CreatedTime().Return(time.Now()).AnyTimes()
+	cli.EXPECT().InspectImage("dist:server").Return(server, nil).AnyTimes()
+
+	labels, err := e.labels(c)
+	if err != nil {
+		t.Fatalf("unexpected error computing labels: %v", err)
+	}
+	if labels[EXTRACT_SOURCE_LABEL] != "bletchid" || labels[EXTRACT_MERGE_LABEL] != "bletchid" {
+		t.Errorf("wrong labels for extraction: %v", labels)
+	}
+	labels[io.ARTIFACTS_LABEL] = `{"/han/config.json":"configdigest","/go/bin/server":"serverdigest"}`
+	cli.EXPECT().ImageLabels("dist:server").Return(labels, nil).AnyTimes()
+
+	//only the artifact in the source tree is looked at, no container is needed
+	first := helper.EXPECT().DigestArtifact("/home/gredo/src/config.json", gomock.Any()).Return("configdigest", nil)
+	if _, ood, err := e.ood(c); err != nil || ood {
+		t.Errorf("expected extraction to be up to date (%v)", err)
+	}
+	helper.EXPECT().DigestArtifact("/home/gredo/src/config.json", gomock.Any()).Return("changed", nil).After(first)
+	if _, ood, err := e.ood(c); err != nil || !ood {
+		t.Errorf("expected extraction to be out of date after its source changed (%v)", err)
+	}
+
+	//a different configuration of the artifacts means a rebuild
+	e.artifacts[1].Mode = "0700"
+	if _, ood, err := e.ood(c); err != nil || !ood {
+		t.Errorf("expected extraction to be out of date after its artifacts changed (%v)", err)
+	}
+}
@@ -0,0 +1,72 @@
+package pickett
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//GC_GRACE is how old a temporary container has to be before gc removes it.  Copy
+//containers exit right away, so a young one could belong to a pickett that is still
+//copying out of it.
+const GC_GRACE = 10 * time.Minute
+
+//CmdGC removes what pickett leaves behind when it is interrupted: temporary containers
+//(the ones it builds in or copies artifacts out of) that are not running, and images it
+//built that have no tag and nothing built on them.  Of the previous images kept for each
+//tag, only the newest keep are left; a negative keep means the Keep of the configuration.
+//Nothing pickett did not create is touched, and temporary containers and dangling images
+//of other projects are left for them.
+func CmdGC(keep int, config *Config) error {
+	conts, err := config.cli.ListTemporaryContainers(config.project)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, c := range conts {
+		if c.Running() || time.Since(time.Unix(c.Created, 0)) < GC_GRACE {
+			flog.Debugf("not removing temporary container %s (%s), it may be in use", c.ID, c.Status)
+			continue
+		}
+		if err := config.cli.CmdRmContainer(c.ID); err != nil {
+			flog.Warningf("unable to remove temporary container %s: %v", c.ID, err)
+			continue
+		}
+		removed++
+	}
+	fmt.Printf("[pickett] removed %d temporary containers\n", removed)
+
+	if keep < 0 {
+		keep = config.DockerBuildOptions.Keep
+	}
+	tags := []string{}
+	for tag := range config.nameToNode {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	removed = 0
+	for _, tag := range tags {
+		n, err := config.removeRetained(tag, keep)
+		removed += n
+		if err != nil {
+			flog.Warningf("%v", err)
+		}
+	}
+	fmt.Printf("[pickett] removed %d old images\n", removed)
+
+	images, err := config.cli.ListDanglingImages(config.project)
+	if err != nil {
+		return err
+	}
+	removed = 0
+	for _, img := range images {
+		//an image a container still uses can't be removed, that's fine
+		if err := config.cli.CmdRmImage(img); err != nil {
+			flog.Debugf("not removing image %s: %v", img, err)
+			continue
+		}
+		removed++
+	}
+	fmt.Printf("[pickett] removed %d dangling images\n", removed)
+	return nil
+}
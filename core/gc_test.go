@@ -0,0 +1,40 @@
+package pickett
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestGCRemovesOnlyLeftovers(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	c := &Config{cli: cli, nameToNode: map[string]node{BLETCH: nil}, project: "proj"}
+
+	old := time.Now().Add(-time.Hour).Unix()
+	cli.EXPECT().ListTemporaryContainers("proj").Return([]*io.ContainerInfo{
+		{ID: "leftover", Status: "Exited (0) 1 hours ago", Created: old},
+		{ID: "building", Status: "Up 1 hours", Created: old},
+		{ID: "copying", Status: "Exited (0) 1 seconds ago", Created: time.Now().Unix()},
+	}, nil)
+	cli.EXPECT().CmdRmContainer("leftover").Return(nil)
+
+	//one old image of bletch is kept, the second goes
+	expectImage(controller, cli, BLETCH+"-2", "older")
+	cli.EXPECT().CmdRmImage(BLETCH + "-2").Return(nil)
+	expectImage(controller, cli, BLETCH+"-3", "")
+
+	cli.EXPECT().ListDanglingImages("proj").Return([]string{"unused", "inuse"}, nil)
+	cli.EXPECT().CmdRmImage("unused").Return(nil)
+	cli.EXPECT().CmdRmImage("inuse").Return(errors.New("conflict"))
+
+	if err := CmdGC(1, c); err != nil {
+		t.Errorf("unexpected error from gc: %v", err)
+	}
+}
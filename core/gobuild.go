@@ -2,6 +2,7 @@ package pickett
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -17,7 +18,21 @@ type goBuilder struct {
 	pkgs       []string
 	testFile   string
 	command    string
-	probe      string
+	perPackage bool
+	cache      string
+
+	//module mode and build settings
+	module  string
+	vendor  bool
+	flags   []string
+	tags    []string
+	goos    string
+	goarch  string
+	ldflags string
+
+	//what go list found in the last ood check, saved in the build record
+	source *goSource
+	test   *goTester
 }
 
 func (g *goBuilder) tag() string {
@@ -25,9 +40,10 @@ func (g *goBuilder) tag() string {
 }
 
 // ood is true if we are older than our build in container.  We are also out of date
-// if source has changed.
+// if any source file of our packages, or the packages they depend on, is not the same as
+// when we were built.
 func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
-	/// this case tests the go source code with a sequence of probes
+	g.source = nil
 
 	t, err := tagToTime(g.tag(), conf.cli)
 	if err != nil {
@@ -60,34 +76,37 @@ func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
 		return t, false, nil
 	}
 
-	/// this case tests the go source code with a sequence of probes
+	/// this case compares the source code with the build record
 
-	//we need to do this to test our source code for OOD
-	runConfig, sequence, err := g.formBuildCommand(conf, true)
+	source, err := g.listSource(conf)
 	if err != nil {
 		return time.Time{}, true, err
 	}
-	for i, seq := range sequence {
-		if seq[0] == "sourceDirChecker" {
-			sdc := NewSourceDirChecker(t)
-			laterTime, err := sdc.Check(conf, seq[1])
-			if err != nil {
-				return time.Time{}, true, nil
-			}
-			if !laterTime.IsZero() {
-				return laterTime, true, nil
-			}
-		} else {
-			//fire for range
-			buf, _, err := conf.cli.CmdRun(runConfig, seq...)
-			if err != nil {
-				return time.Time{}, true, err
-			}
-			if buf.Len() != 0 {
-				flog.Infof("Building %s, out of date with respect to source in %s.", g.tag(), g.pkgs[i])
-				return time.Time{}, true, nil
-			}
-		}
+	g.source = source
+
+	rec, err := loadBuildRecord(g.tag(), conf.etcd)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if rec == nil {
+		flog.Infof("Building %s, no record of the source it was built from.", g.tag())
+		return time.Time{}, true, nil
+	}
+	insp, err := conf.cli.InspectImage(g.tag())
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if rec.ImageID != insp.ID() {
+		flog.Infof("Building %s, the image was not built by pickett from this source.", g.tag())
+		return time.Time{}, true, nil
+	}
+	if rec.Settings != g.settings() {
+		flog.Infof("Building %s, the build settings have changed.", g.tag())
+		return time.Time{}, true, nil
+	}
+	if changed := rec.changed(source.digests); changed != "" {
+		flog.Infof("Building %s, out of date with respect to %s.", g.tag(), changed)
+		return time.Time{}, true, nil
 	}
 
 	flog.Infof("'%s' is up to date with respect to its source code.", g.tag())
@@ -96,68 +115,240 @@ func (g *goBuilder) ood(conf *Config) (time.Time, bool, error) {
 
 type runCommand []string
 
-//formBuildCommand is a helper for forming the sequence of build-related commands to
-//either probe for code out of date or build it.
-func (g *goBuilder) formBuildCommand(conf *Config, dontExecute bool) (*io.RunConfig, []runCommand, error) {
-
-	attach := true
-	waitOutput := true
+//multiPackageCommands are the go commands that take any number of packages, so that all
+//of ours can be built with one run.
+var multiPackageCommands = []string{"install", "build", "test", "vet", "get"}
 
-	if dontExecute {
-		attach = false
-	}
+//MODULE_MOUNT is where the module directory is mounted in module mode.  The go tool runs
+//there, so the packages are relative to it.
+const MODULE_MOUNT = "/pickett-module"
 
+//runConfig returns how to run the go tool for this build: the code volumes (and the module
+//in module mode) are mounted, and the cache and target platform are set up.
+func (g *goBuilder) runConfig(conf *Config) (*io.RunConfig, error) {
 	volumes, err := conf.codeVolumes()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	resultConfig := &io.RunConfig{
-		Attach:     attach,
-		WaitOutput: waitOutput,
+	result := &io.RunConfig{
+		WaitOutput: true,
 		Volumes:    volumes,
 		Image:      g.runIn.name(),
+		Temporary:  true,
+		Project:    conf.project,
+	}
+	if g.module != "" {
+		dir, err := conf.daemonPath("", conf.helper.DirectoryRelative(g.module))
+		if err != nil {
+			return nil, err
+		}
+		result.Volumes[dir] = MODULE_MOUNT
+		result.WorkDir = MODULE_MOUNT
+		result.Env = append(result.Env, "GO111MODULE=on")
+	}
+	if g.goos != "" {
+		result.Env = append(result.Env, "GOOS="+g.goos)
+	}
+	if g.goarch != "" {
+		result.Env = append(result.Env, "GOARCH="+g.goarch)
 	}
+	if err := addCache(conf.project, g.cache, result, conf.cli); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	var baseCmd []string
-	if dontExecute {
-		baseCmd = strings.Split(strings.Trim(g.probe, " \n"), " ")
-	} else {
-		baseCmd = strings.Split(strings.Trim(g.command, " \n"), " ")
+//selectionFlags are the flags that change which files are part of the build, so they
+//are given to go list as well as the build.
+func (g *goBuilder) selectionFlags() []string {
+	result := []string{}
+	if len(g.tags) > 0 {
+		result = append(result, "-tags", strings.Join(g.tags, ","))
 	}
-	sequence := []runCommand{}
+	if g.module != "" && g.vendor {
+		result = append(result, "-mod=vendor")
+	}
+	return result
+}
+
+//buildFlags returns the flags given to the build command, before the packages.
+func (g *goBuilder) buildFlags() []string {
+	result := append(append([]string{}, g.flags...), g.selectionFlags()...)
+	if g.ldflags != "" {
+		result = append(result, "-ldflags", g.ldflags)
+	}
+	return result
+}
+
+//packages returns the packages to build.  In module mode they are relative to the module.
+func (g *goBuilder) packages() []string {
+	if g.module == "" {
+		return g.pkgs
+	}
+	result := []string{}
 	for _, p := range g.pkgs {
-		rc := runCommand(append(baseCmd, p))
+		if !strings.HasPrefix(p, ".") {
+			p = "./" + p
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+//settings summarizes how we build.  It is kept in the build record, since a change to it
+//means a rebuild even if the source is the same.
+func (g *goBuilder) settings() string {
+	return strings.Join([]string{g.command, strings.Join(g.buildFlags(), " "),
+		"GOOS=" + g.goos, "GOARCH=" + g.goarch}, "|")
+}
+
+//hostPath returns where a file the go tool sees is on this machine, if it is in the
+//module or a code volume.
+func (g *goBuilder) hostPath(conf *Config, file string) (string, bool) {
+	if g.module != "" && (file == MODULE_MOUNT || strings.HasPrefix(file, MODULE_MOUNT+"/")) {
+		return filepath.Join(conf.helper.DirectoryRelative(g.module), file[len(MODULE_MOUNT):]), true
+	}
+	return conf.hostPath(file)
+}
+
+//formBuildCommand is a helper for forming the sequence of commands that build the
+//packages.  Unless we are told to build one package at a time, or the command is not one
+//that takes several packages, the sequence is a single command for all of them.
+func (g *goBuilder) formBuildCommand(conf *Config) (*io.RunConfig, []runCommand, error) {
+	resultConfig, err := g.runConfig(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	resultConfig.Attach = true
+
+	baseCmd := strings.Split(strings.Trim(g.command, " \n"), " ")
+	baseCmd = append(baseCmd, g.buildFlags()...)
+	if !g.perPackage && len(baseCmd) >= 2 && baseCmd[0] == "go" && contains(multiPackageCommands, baseCmd[1]) {
+		all := append(append([]string{}, baseCmd...), g.packages()...)
+		return resultConfig, []runCommand{runCommand(all)}, nil
+	}
+	sequence := []runCommand{}
+	for _, p := range g.packages() {
+		rc := runCommand(append(append([]string{}, baseCmd...), p))
 		sequence = append(sequence, rc)
 	}
 
 	return resultConfig, sequence, nil
 }
 
-//build does the work of actually building go source code.
+//goSource is what go list told us about the source of a build.
+type goSource struct {
+	packages map[string]*goListPackage //by import path
+	hosts    map[string]string         //file as the go tool sees it to where it is here
+	digests  map[string]string         //host path to digest
+	extra    []string                  //host paths of files that are not in a package, like go.sum
+}
+
+//listSource lists the source files of our packages and everything they depend on with
+//"go list" (a single container) and finds the digest of each that lives in a code volume
+//or the module.  Files that do not are part of the RunIn image, which we already compare
+//with, or are in modules pinned by go.sum, which is included.
+func (g *goBuilder) listSource(conf *Config) (*goSource, error) {
+	runConfig, err := g.runConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	cmd := append([]string{"go", "list", "-e", "-deps", "-json"}, g.selectionFlags()...)
+	cmd = append(cmd, g.packages()...)
+	buf, contId, err := conf.cli.CmdRun(runConfig, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	if err := conf.cli.CmdRmContainer(contId); err != nil {
+		flog.Debugf("unable to remove go list container %s: %v", contId, err)
+	}
+	pkgs, err := parseGoList(buf.String())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &goSource{
+		packages: make(map[string]*goListPackage),
+		hosts:    make(map[string]string),
+		digests:  make(map[string]string),
+	}
+	files := []string{}
+	for _, pkg := range pkgs {
+		if pkg.Error != nil {
+			return nil, fmt.Errorf("go list failed on %s: %s", pkg.ImportPath, pkg.Error.Err)
+		}
+		result.packages[pkg.ImportPath] = pkg
+		if !pkg.Standard {
+			files = append(files, pkg.files()...)
+		}
+	}
+	extra := []string{}
+	if g.module != "" {
+		extra = append(extra, MODULE_MOUNT+"/go.mod", MODULE_MOUNT+"/go.sum")
+		if g.vendor {
+			extra = append(extra, MODULE_MOUNT+"/vendor/modules.txt")
+		}
+	}
+
+	for _, file := range append(files, extra...) {
+		host, ok := g.hostPath(conf, file)
+		if !ok {
+			flog.Debugf("%s is not in a code volume, ignoring it", file)
+			continue
+		}
+		digest, err := conf.helper.DigestFile(host)
+		if err != nil {
+			return nil, err
+		}
+		result.hosts[file] = host
+		result.digests[host] = digest
+	}
+	for _, file := range extra {
+		result.extra = append(result.extra, result.hosts[file])
+	}
+	return result, nil
+}
+
+//build does the work of actually building go source code.  When the build is a sequence
+//of commands each one runs in the image committed from the one before.  The containers
+//are removed as we go, and the image the tag used to point at is removed at the end if
+//nothing else is using it (and old images are not being kept).
 func (g *goBuilder) build(conf *Config) (time.Time, error) {
 
-	runConfig, sequence, err := g.formBuildCommand(conf, false)
+	runConfig, sequence, err := g.formBuildCommand(conf)
 	if err != nil {
 		return time.Time{}, err
 	}
+	previous := ""
+	if insp, err := conf.cli.InspectImage(g.tag()); err == nil {
+		previous = insp.ID()
+	}
 	img := runConfig.Image
 
 	for _, seq := range sequence {
 		runConfig.Image = img
 		_, contId, err := conf.cli.CmdRun(runConfig, seq...)
 		if err != nil {
+			g.removeContainer(conf, contId)
 			return time.Time{}, err
 		}
 		//update the image
 		img, err = conf.cli.CmdCommit(contId, nil)
+		g.removeContainer(conf, contId)
 		if err != nil {
 			return time.Time{}, err
 		}
 	}
 
+	//an image that fails its tests is not tagged
+	if g.test != nil {
+		if err := g.runTests(conf, img); err != nil {
+			return time.Time{}, err
+		}
+	}
+
 	//command was ok, we need to tag it now
-	err = conf.cli.CmdTag(img, true, &io.TagInfo{g.repository, g.tagname})
+	err = conf.cli.CmdTag(img, true, &io.TagInfo{Repository: g.repository, Tag: g.tagname})
 	if err != nil {
 		return time.Time{}, fmt.Errorf("failed trying to commit (%s): %v", g.tag(), err)
 	}
@@ -165,43 +356,39 @@ func (g *goBuilder) build(conf *Config) (time.Time, error) {
 	if err != nil {
 		return time.Time{}, fmt.Errorf("failed trying to inspect (%s): %v", g.tag(), err)
 	}
-	return insp.CreatedTime(), nil
-}
-
-func (g *goBuilder) in() []node {
-	return []node{
-		g.runIn,
+	if previous != "" && previous != insp.ID() && conf.DockerBuildOptions.Keep == 0 {
+		if err := conf.cli.CmdRmImage(previous); err != nil {
+			flog.Debugf("not removing the old image of %s (%s): %v", g.tag(), previous, err)
+		}
 	}
-}
-
-//
-// sourceDirChecker is a utility type for doing cehcks of a sequence of
-// directories (and their subdirs).
-//
-type sourceDirChecker struct {
-	target time.Time
-}
 
-func NewSourceDirChecker(t time.Time) *sourceDirChecker {
-	return &sourceDirChecker{
-		target: t,
+	//godeps builds are checked against the test file, not the build record
+	if g.testFile == "" {
+		if g.source == nil {
+			if g.source, err = g.listSource(conf); err != nil {
+				return time.Time{}, err
+			}
+		}
+		rec := &buildRecord{ImageID: insp.ID(), Settings: g.settings(), Sources: g.source.digests}
+		if err := rec.save(g.tag(), conf.etcd); err != nil {
+			return time.Time{}, err
+		}
 	}
+	return insp.CreatedTime(), nil
 }
 
-//Check that the path(relative to the config file) is up to date.
-//Returns time's zero if everything is older than our target time. Returns the time
-//if found something newer than target (there might be others).  This
-//function checks subdirectories, so you should pass the root directory of
-//the check you want to perform.
-func (s *sourceDirChecker) Check(config *Config, path string) (time.Time, error) {
-	t, err := config.helper.LastTimeInDirRelative(path)
-	if err != nil {
-		flog.Errorf("checking timestamp failed during sourceDirChecker: %v, %v", path, err)
-		return time.Time{}, err
+//removeContainer removes a container used to build, if there is one.
+func (g *goBuilder) removeContainer(conf *Config, contId string) {
+	if contId == "" {
+		return
+	}
+	if err := conf.cli.CmdRmContainer(contId); err != nil {
+		flog.Warningf("unable to remove build container %s of %s: %v", contId, g.tag(), err)
 	}
+}
 
-	if t.After(s.target) {
-		return t, nil
+func (g *goBuilder) in() []node {
+	return []node{
+		g.runIn,
 	}
-	return time.Time{}, nil
 }
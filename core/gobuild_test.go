@@ -46,15 +46,19 @@ func TestGoPackagesFailOnBuildStep2(t *testing.T) {
 
 	//we want to start a build of "chattanooga"
 	fakeInspectError := fmt.Errorf("no such tag, BOOONG you lose")
-	cli.EXPECT().InspectImage("fart:chattanooga").Return(nil, fakeInspectError)
+	cli.EXPECT().InspectImage("fart:chattanooga").Return(nil, fakeInspectError).Times(2)
+	expectLock(etcd, "/pickett/locks/builds/fart:chattanooga")
 
-	// mock out the docker api calls to build/test the software
+	// mock out the docker api calls to build/test the software, chattanooga is built
+	// one package at a time
 	first := cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "p4...").Return(nil, "some_cont", nil)
 	fakeErr := errors.New("whoa doggie")
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "p5/p6").Return(nil, "", fakeErr).After(first)
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "p5/p6").Return(nil, "bad_cont", fakeErr).After(first)
 
-	//one commits, one for each successful build
+	//one commits, one for each successful build, and both containers are cleaned up
 	cli.EXPECT().CmdCommit("some_cont", nil)
+	cli.EXPECT().CmdRmContainer("some_cont").Return(nil)
+	cli.EXPECT().CmdRmContainer("bad_cont").Return(nil)
 
 	if err := c.Build("fart:chattanooga"); err != fakeErr {
 		t.Errorf("failed to get expected error: %v", err)
@@ -82,22 +86,63 @@ func TestGoPackagesAllBuilt(t *testing.T) {
 	insp := io.NewMockInspectedImage(controller)
 	insp.EXPECT().CreatedTime().Return(now)
 
-	first := cli.EXPECT().InspectImage("test:nashville").Return(nil, fakeInspectError)
-	cli.EXPECT().InspectImage("test:nashville").Return(insp, nil).After(first)
+	first := cli.EXPECT().InspectImage("test:nashville").Return(nil, fakeInspectError).Times(2)
+	built := cli.EXPECT().InspectImage("test:nashville").Return(insp, nil).After(first)
+	expectLock(etcd, "/pickett/locks/builds/test:nashville")
 
 	// test we are already sure we need to build, so we don't test to see if OOD
-	// via go, just run the build
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p1...").Return(nil, "bah", nil)
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p2/p3").Return(nil, "humbug", nil)
+	// via go, just run the build.  go test takes all the packages at once.
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p1...", "p2/p3").Return(nil, "humbug", nil)
 
-	cli.EXPECT().CmdCommit("bah", nil)
 	cli.EXPECT().CmdCommit("humbug", nil).Return("imagehumbug", nil)
-	cli.EXPECT().CmdTag("imagehumbug", true, &io.TagInfo{"test", "nashville"})
+	cli.EXPECT().CmdRmContainer("humbug").Return(nil)
+	cli.EXPECT().CmdTag("imagehumbug", true, &io.TagInfo{Repository: "test", Tag: "nashville"})
+
+	//there was no check of the source, so it is listed after the build for the record
+	insp.EXPECT().ID().Return("imagehumbug")
+	expectGoList(cli, helper, "bbb")
+	etcd.EXPECT().Put("/pickett/builds/test:nashville", gomock.Any())
+	expectHistory(controller, helper, cli, etcd, "test:nashville", "imagehumbug", built)
 
 	//hit it!
 	c.Build("test:nashville")
 }
 
+//goListOutput is what "go list -deps -json" prints for nashville's packages, with some
+//stderr mixed in as it is when we read it from docker.
+var goListOutput = `go: finding p2/p3
+{
+	"Dir": "/han/p1",
+	"ImportPath": "p1",
+	"GoFiles": ["a.go"]
+}
+{
+	"Dir": "/usr/local/go/src/fmt",
+	"ImportPath": "fmt",
+	"Standard": true,
+	"GoFiles": ["print.go"]
+}
+{
+	"Dir": "/han/p2/p3",
+	"ImportPath": "p2/p3",
+	"GoFiles": ["b.go"],
+	"TestGoFiles": ["b_test.go"]
+}
+`
+
+//expectGoList sets up the go list of nashville's packages and the digests of the source.
+func expectGoList(cli *io.MockDockerCli, helper *io.MockHelper, digestOfB string) {
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "list", "-e", "-deps", "-json", "p1...", "p2/p3").
+		Return(bytes.NewBufferString(goListOutput), "listcont", nil)
+	cli.EXPECT().CmdRmContainer("listcont").Return(nil)
+	helper.EXPECT().DigestFile("/home/gredo/src/p1/a.go").Return("aaa", nil)
+	helper.EXPECT().DigestFile("/home/gredo/src/p2/p3/b.go").Return(digestOfB, nil)
+	helper.EXPECT().DigestFile("/home/gredo/src/p2/p3/b_test.go").Return("ccc", nil)
+}
+
+const nashvilleRecord = `{"ImageID":"nashvilleid","Settings":"go test||GOOS=|GOARCH=","Sources":{"/home/gredo/src/p1/a.go":"aaa",` +
+	`"/home/gredo/src/p2/p3/b.go":"bbb","/home/gredo/src/p2/p3/b_test.go":"ccc"}}`
+
 func TestGoPackagesOODOnSource(t *testing.T) {
 	controller := gomock.NewController(t)
 	defer controller.Finish()
@@ -107,62 +152,380 @@ func TestGoPackagesOODOnSource(t *testing.T) {
 	etcd := io.NewMockEtcdClient(controller)
 
 	c := setupForDontBuildBletch(controller, helper, cli, etcd)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
 
-	//this is called to figure out how to mount the source... we don't care how many
-	//times this happens
-	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/project/bounty/src").AnyTimes()
+	//the image exists and was built after bletch, so it comes down to the source
+	now := time.Now()
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().CreatedTime().Return(now)
+	insp.EXPECT().ID().Return("nashvilleid").Times(2)
+	first := cli.EXPECT().InspectImage("test:nashville").Return(insp, nil).Times(3)
+
+	//b.go is not what it was when nashville was built
+	expectGoList(cli, helper, "changed")
+	etcd.EXPECT().Get("/pickett/builds/test:nashville").Return(nashvilleRecord, true, nil)
+
+	expectLock(etcd, "/pickett/locks/builds/test:nashville")
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p1...", "p2/p3").Return(nil, "cont1", nil)
+	cli.EXPECT().CmdCommit("cont1", nil).Return("newid", nil)
+	cli.EXPECT().CmdRmContainer("cont1").Return(nil)
+	cli.EXPECT().CmdTag("newid", true, &io.TagInfo{Repository: "test", Tag: "nashville"})
+
+	//the tag now points at the new image, so the old one is removed
+	built := io.NewMockInspectedImage(controller)
+	built.EXPECT().CreatedTime().Return(now)
+	built.EXPECT().ID().Return("newid").Times(2)
+	last := cli.EXPECT().InspectImage("test:nashville").Return(built, nil).After(first)
+	cli.EXPECT().CmdRmImage("nashvilleid").Return(nil)
+
+	//the new record has the source as it was when we checked
+	etcd.EXPECT().Put("/pickett/builds/test:nashville", gomock.Any()).Do(func(key string, value string) {
+		if !strings.Contains(value, `"/home/gredo/src/p2/p3/b.go":"changed"`) {
+			t.Errorf("build record has the wrong source: %s", value)
+		}
+	})
+	expectHistory(controller, helper, cli, etcd, "test:nashville", "newid", last)
+
+	if err := c.Build("test:nashville"); err != nil {
+		t.Errorf("unexpected error building: %v", err)
+	}
+}
+
+func TestGoPackagesUpToDate(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	c := setupForDontBuildBletch(controller, helper, cli, etcd)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
 
-	//we want to suggest that the nashville container was built recently when first
-	//asked... we will be asked a second time, but it gets discarded so we just
-	//do the same thing both times
 	now := time.Now()
 	insp := io.NewMockInspectedImage(controller)
-	insp.EXPECT().CreatedTime().Return(now).Times(2)
+	insp.EXPECT().CreatedTime().Return(now)
+	insp.EXPECT().ID().Return("nashvilleid")
 	cli.EXPECT().InspectImage("test:nashville").Return(insp, nil).Times(2)
 
-	//
-	// this is the test of how the go source OOD really works
-	//
-
-	// test for code build needed, then build it
-	runConfigBase := io.RunConfig{
-		Image: "blah/bletch",
-		Volumes: map[string]string{
-			"/vagrant/src": "/han",
-		},
-	}
-	probe := runConfigBase
-	probe.Attach = false
-
-	build := runConfigBase
-	build.Attach = true
-
-	buffer := new(bytes.Buffer)
-	buffer.WriteString("stuff")
-
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "-n", "p1...").Return(new(bytes.Buffer), "", nil)
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p1...").Return(nil, "cont1", nil)
-
-	//test for code build needed, then build it
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "install", "-n", "p2/p3").Return(buffer, "", nil)
-	cli.EXPECT().CmdRun(gomock.Any(), "go", "test", "p2/p3").Return(nil, "cont2", nil)
-
-	//
-	//Fake the results of the two "probes" with -n, we want to return true (meaning that
-	//there is no output, thus to code is up to date) on the first one.  we return false
-	//on the second one and it needs to be the second one because the system won't
-	//bother asking about the second one if the first one already means we are OOD
-	//
-	//first := cli.EXPECT().EmptyOutput(true).Return(true)
-	//cli.EXPECT().EmptyOutput(true).Return(false).After(first)
-
-	//after we build successfully, we use "ps -q -l" to check to see the id of
-	//the container that we built in.
-	//expectContainerPSAndCommit(cli)
-	cli.EXPECT().CmdCommit("cont1", nil).Return("someid", nil)
-	cli.EXPECT().CmdCommit("cont2", nil).Return("someotherid", nil)
-	cli.EXPECT().CmdTag("someotherid", true, &io.TagInfo{"test", "nashville"})
-	//hit it!
-	c.Build("test:nashville")
+	//nothing has changed, so nothing is built
+	expectGoList(cli, helper, "bbb")
+	etcd.EXPECT().Get("/pickett/builds/test:nashville").Return(nashvilleRecord, true, nil)
+
+	if err := c.Build("test:nashville"); err != nil {
+		t.Errorf("unexpected error building: %v", err)
+	}
+}
+
+var cacheExample = `
+{
+	"CodeVolumes" : [
+		{
+			"Directory" : "src",
+			"MountedAt" : "/han"
+		}
+	],
+	"Containers" : [
+		{
+			"Repository": "blah",
+			"Tag" : "bletch",
+			"Directory" : "mydir"
+		}
+	],
+	"GoBuilds" : [
+		{
+			"Repository": "test",
+			"RunIn" : "blah:bletch",
+			"Packages": ["p1..."],
+			"Tag": "cached",
+			"Cache": "gocache"
+		}
+	]
+}
+`
+
+func TestGoBuildUsesCache(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(cacheExample), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	c.project = "mine"
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src")
+	cli.EXPECT().CmdCreateVolume("pickett-cache-mine-gocache", map[string]string{CACHE_LABEL: "gocache", io.PROJECT_LABEL: "mine"}).Return(nil)
+
+	g := c.nameToNode["test:cached"].implementation().(*goBuilder)
+	rc, _, err := g.formBuildCommand(c)
+	if err != nil {
+		t.Fatalf("unexpected error forming build command: %v", err)
+	}
+	if rc.Volumes["pickett-cache-mine-gocache"] != CACHE_MOUNT || rc.Volumes["/home/gredo/src"] != "/han" {
+		t.Errorf("wrong volumes for a cached build: %v", rc.Volumes)
+	}
+	env := strings.Join(rc.Env, " ")
+	if env != "GOCACHE=/pickett-cache/build GOMODCACHE=/pickett-cache/mod" {
+		t.Errorf("wrong environment for a cached build: %s", env)
+	}
+
+	bad := strings.Replace(cacheExample, `"gocache"`, `"no/slashes"`, 1)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	if _, err := NewConfig(strings.NewReader(bad), helper, cli, nil); err == nil {
+		t.Errorf("expected an error from a bad cache name")
+	}
+}
+
+func TestCachePruneOnlyTouchesTheProject(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	c := &Config{cli: cli, project: "mine"}
+	cli.EXPECT().CmdListVolumes(CACHE_LABEL).Return([]*io.VolumeInfo{
+		{Name: "pickett-cache-mine-gocache", Labels: map[string]string{CACHE_LABEL: "gocache", io.PROJECT_LABEL: "mine"}},
+		{Name: "pickett-cache-theirs-gocache", Labels: map[string]string{CACHE_LABEL: "gocache", io.PROJECT_LABEL: "theirs"}},
+		{Name: "pickett-cache-gocache", Labels: map[string]string{CACHE_LABEL: "gocache"}},
+	}, nil)
+	cli.EXPECT().CmdRmVolume("pickett-cache-mine-gocache").Return(nil)
+
+	if err := CmdCache("prune", nil, c); err != nil {
+		t.Errorf("unexpected error pruning: %v", err)
+	}
+}
+
+var moduleExample = `
+{
+	"Containers" : [
+		{
+			"Repository": "blah",
+			"Tag" : "bletch",
+			"Directory" : "mydir"
+		}
+	],
+	"GoBuilds" : [
+		{
+			"Repository": "test",
+			"RunIn" : "blah:bletch",
+			"Module": "mymod",
+			"Vendor": true,
+			"Packages": ["cmd/server", "./cmd/client"],
+			"Tags": ["netgo", "prod"],
+			"LDFlags": "-X main.version=1.2",
+			"GOOS": "linux",
+			"GOARCH": "arm64",
+			"Tag": "mod"
+		}
+	]
+}
+`
 
+func TestGoBuildModuleMode(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().OpenFileRelative("mymod/go.mod").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(moduleExample), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	helper.EXPECT().DirectoryRelative("mymod").Return("/home/gredo/mymod").AnyTimes()
+
+	g := c.nameToNode["test:mod"].implementation().(*goBuilder)
+	rc, seq, err := g.formBuildCommand(c)
+	if err != nil {
+		t.Fatalf("unexpected error forming build command: %v", err)
+	}
+	if rc.Volumes["/home/gredo/mymod"] != MODULE_MOUNT || rc.WorkDir != MODULE_MOUNT {
+		t.Errorf("module not mounted as the working directory: %v in %s", rc.Volumes, rc.WorkDir)
+	}
+	if env := strings.Join(rc.Env, " "); env != "GO111MODULE=on GOOS=linux GOARCH=arm64" {
+		t.Errorf("wrong environment for a module build: %s", env)
+	}
+	expected := "go install -tags netgo,prod -mod=vendor -ldflags -X main.version=1.2 ./cmd/server ./cmd/client"
+	if len(seq) != 1 || strings.Join(seq[0], " ") != expected {
+		t.Errorf("wrong build command, expected '%s' but got %v", expected, seq)
+	}
+
+	//go.mod, go.sum and the vendored module list are part of the source
+	list := `{
+	"Dir": "/pickett-module/cmd/server",
+	"ImportPath": "example.com/mod/cmd/server",
+	"GoFiles": ["main.go"]
+}
+{
+	"Dir": "/go/pkg/mod/example.com/dep@v1.0.0",
+	"ImportPath": "example.com/dep",
+	"GoFiles": ["dep.go"]
+}
+`
+	cli.EXPECT().CmdRun(gomock.Any(), "go", "list", "-e", "-deps", "-json", "-tags", "netgo,prod", "-mod=vendor",
+		"./cmd/server", "./cmd/client").Return(bytes.NewBufferString(list), "listcont", nil)
+	cli.EXPECT().CmdRmContainer("listcont").Return(nil)
+	for _, f := range []string{"cmd/server/main.go", "go.mod", "go.sum", "vendor/modules.txt"} {
+		helper.EXPECT().DigestFile("/home/gredo/mymod/"+f).Return(f+"-digest", nil)
+	}
+	source, err := g.listSource(c)
+	if err != nil {
+		t.Fatalf("unexpected error listing source: %v", err)
+	}
+	sources := source.digests
+	if len(sources) != 4 || sources["/home/gredo/mymod/go.sum"] != "go.sum-digest" {
+		t.Errorf("wrong source for a module build: %v", sources)
+	}
+}
+
+//hasEnv matches a RunConfig with exactly the environment given.
+type hasEnv string
+
+func (m hasEnv) Matches(x interface{}) bool {
+	rc, ok := x.(*io.RunConfig)
+	return ok && strings.Join(rc.Env, " ") == string(m)
+}
+
+func (m hasEnv) String() string {
+	return fmt.Sprintf("has environment %s", string(m))
+}
+
+func TestGoBuildRunsTests(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	withTest := strings.Replace(moduleExample, `"Tag": "mod"`,
+		`"Tag": "mod", "Test": {"Flags": ["-race"], "Report": "out/junit.xml"}`, 1)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().OpenFileRelative("mymod/go.mod").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(withTest), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	helper.EXPECT().DirectoryRelative("mymod").Return("/home/gredo/mymod").AnyTimes()
+
+	g := c.nameToNode["test:mod"].implementation().(*goBuilder)
+	server := &goListPackage{Dir: "/pickett-module/cmd/server", ImportPath: "example.com/mod/cmd/server",
+		GoFiles: []string{"main.go"}, TestGoFiles: []string{"main_test.go"}}
+	client := &goListPackage{Dir: "/pickett-module/cmd/client", ImportPath: "example.com/mod/cmd/client",
+		GoFiles: []string{"main.go"}}
+	g.source = &goSource{
+		packages: map[string]*goListPackage{server.ImportPath: server, client.ImportPath: client},
+		hosts: map[string]string{"/pickett-module/cmd/server/main.go": "/home/gredo/mymod/cmd/server/main.go",
+			"/pickett-module/cmd/server/main_test.go": "/home/gredo/mymod/cmd/server/main_test.go",
+			"/pickett-module/cmd/client/main.go":      "/home/gredo/mymod/cmd/client/main.go"},
+		digests: map[string]string{"/home/gredo/mymod/cmd/server/main.go": "s1",
+			"/home/gredo/mymod/cmd/server/main_test.go": "s2", "/home/gredo/mymod/cmd/client/main.go": "c1"},
+	}
+
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().ID().Return("blahid")
+	cli.EXPECT().InspectImage("blah:bletch").Return(insp, nil)
+
+	//the client passed before with the same source, the server has never been tested
+	cached := fmt.Sprintf(`{"Key":"%s","Result":{"Package":"example.com/mod/cmd/client","Result":"pass"}}`,
+		g.testKey(client, "blahid"))
+	etcd.EXPECT().Get("/pickett/tests/test:mod/example.com/mod/cmd/client").Return(cached, true, nil)
+	etcd.EXPECT().Get("/pickett/tests/test:mod/example.com/mod/cmd/server").Return("", false, nil)
+
+	events := `{"Action":"run","Package":"example.com/mod/cmd/server","Test":"TestA"}
+{"Action":"output","Package":"example.com/mod/cmd/server","Test":"TestA","Output":"main_test.go:9: wrong\n"}
+{"Action":"fail","Package":"example.com/mod/cmd/server","Test":"TestA","Elapsed":0.1}
+{"Action":"run","Package":"example.com/mod/cmd/server","Test":"TestB"}
+{"Action":"pass","Package":"example.com/mod/cmd/server","Test":"TestB","Elapsed":0.2}
+{"Action":"fail","Package":"example.com/mod/cmd/server","Elapsed":0.3}
+`
+	//the build is for linux/arm64, but the tests run where the container does
+	cli.EXPECT().CmdRun(hasEnv("GO111MODULE=on"), "go", "test", "-json", "-tags", "netgo,prod", "-mod=vendor", "-race",
+		"example.com/mod/cmd/server").Return(bytes.NewBufferString(events), "testcont", errors.New("exit 1"))
+	cli.EXPECT().CmdRmContainer("testcont").Return(nil)
+	etcd.EXPECT().Del("/pickett/tests/test:mod/example.com/mod/cmd/server").Return("", nil)
+
+	var report string
+	helper.EXPECT().WriteFileRelative("out/junit.xml", gomock.Any()).Do(func(path string, content []byte) {
+		report = string(content)
+	}).Return(nil)
+
+	err = g.runTests(c, "newimage")
+	if err == nil || !strings.Contains(err.Error(), "example.com/mod/cmd/server") {
+		t.Errorf("expected the failing package to be reported but got %v", err)
+	}
+	for _, expected := range []string{`<testsuite name="example.com/mod/cmd/server" tests="2" failures="1"`,
+		`<testcase classname="example.com/mod/cmd/server" name="TestA" time="0.100">`,
+		`<failure message="Failed">main_test.go:9: wrong`} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected %s in the report:\n%s", expected, report)
+		}
+	}
+}
+
+func TestGoBuildPlatforms(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+
+	platforms := strings.Replace(moduleExample, `"GOOS": "linux",
+			"GOARCH": "arm64",`, `"Platforms": ["linux/amd64", "darwin/arm64"],`, 1)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().OpenFileRelative("mymod/go.mod").Return(nil, nil)
+	c, err := NewConfig(strings.NewReader(platforms), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	helper.EXPECT().DirectoryRelative("mymod").Return("/home/gredo/mymod").AnyTimes()
+
+	for name, env := range map[string]string{
+		"test:mod-linux-amd64":  "GO111MODULE=on GOOS=linux GOARCH=amd64",
+		"test:mod-darwin-arm64": "GO111MODULE=on GOOS=darwin GOARCH=arm64",
+	} {
+		n, ok := c.nameToNode[name]
+		if !ok {
+			t.Fatalf("no node for platform %s", name)
+		}
+		rc, _, err := n.implementation().(*goBuilder).formBuildCommand(c)
+		if err != nil {
+			t.Fatalf("unexpected error forming build command: %v", err)
+		}
+		if strings.Join(rc.Env, " ") != env {
+			t.Errorf("wrong environment for %s: %v", name, rc.Env)
+		}
+	}
+
+	//test:mod is the first platform, tagged again
+	group := c.nameToNode["test:mod"].implementation().(*platformsBuilder)
+	if len(group.in()) != 2 || group.in()[0].name() != "test:mod-linux-amd64" {
+		t.Fatalf("wrong platforms in test:mod: %v", group.in())
+	}
+	first := io.NewMockInspectedImage(controller)
+	first.EXPECT().ID().Return("linuxid").AnyTimes()
+	first.EXPECT().CreatedTime().Return(time.Now()).AnyTimes()
+	old := io.NewMockInspectedImage(controller)
+	old.EXPECT().ID().Return("oldid")
+	cli.EXPECT().InspectImage("test:mod-linux-amd64").Return(first, nil).Times(2)
+	cli.EXPECT().InspectImage("test:mod").Return(old, nil)
+	if _, ood, err := group.ood(c); err != nil || !ood {
+		t.Errorf("expected test:mod to be out of date with its first platform (%v)", err)
+	}
+	cli.EXPECT().CmdTag("linuxid", true, &io.TagInfo{Repository: "test", Tag: "mod"}).Return(nil)
+	if _, err := group.build(c); err != nil {
+		t.Errorf("unexpected error tagging test:mod: %v", err)
+	}
+
+	bad := strings.Replace(platforms, "darwin/arm64", "darwin", 1)
+	helper.EXPECT().OpenDockerfileRelative("mydir").Return(nil, nil)
+	helper.EXPECT().OpenFileRelative("mymod/go.mod").Return(nil, nil)
+	if _, err := NewConfig(strings.NewReader(bad), helper, cli, nil); err == nil {
+		t.Errorf("expected an error from a platform without an architecture")
+	}
 }
@@ -0,0 +1,70 @@
+package pickett
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+//goListPackage is the part of the output of "go list -json" that we use.
+type goListPackage struct {
+	Dir          string
+	ImportPath   string
+	Standard     bool
+	GoFiles      []string
+	CgoFiles     []string
+	CFiles       []string
+	CXXFiles     []string
+	HFiles       []string
+	SFiles       []string
+	SysoFiles    []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+	EmbedFiles   []string
+	Deps         []string
+	TestImports  []string
+	XTestImports []string
+	DepOnly      bool
+	Error        *struct {
+		Err string
+	}
+}
+
+//files returns the full paths (inside the container) of the source files of the package.
+func (p *goListPackage) files() []string {
+	result := []string{}
+	for _, list := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles,
+		p.SFiles, p.SysoFiles, p.TestGoFiles, p.XTestGoFiles, p.EmbedFiles} {
+		for _, f := range list {
+			result = append(result, path.Join(p.Dir, f))
+		}
+	}
+	return result
+}
+
+//parseGoList reads the packages printed by "go list -json".  The output we get from docker
+//has stderr mixed in, so anything that is not one of the objects go list prints (which
+//start and end with a brace alone on a line) is ignored.
+func parseGoList(output string) ([]*goListPackage, error) {
+	result := []*goListPackage{}
+	var current []string
+	for _, line := range strings.Split(output, "\n") {
+		if current == nil {
+			if line == "{" {
+				current = []string{line}
+			}
+			continue
+		}
+		current = append(current, line)
+		if line != "}" {
+			continue
+		}
+		pkg := &goListPackage{}
+		if err := json.Unmarshal([]byte(strings.Join(current, "\n")), pkg); err != nil {
+			return nil, err
+		}
+		result = append(result, pkg)
+		current = nil
+	}
+	return result, nil
+}
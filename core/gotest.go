@@ -0,0 +1,345 @@
+package pickett
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//goTester is the Test section of a go build: the packages of the build are tested in the
+//image that was just built, before it is tagged.
+type goTester struct {
+	flags  []string
+	report string //JUnit XML, relative to the configuration file
+}
+
+//testEvent is one line of the output of "go test -json".
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+//packageResult is the result of testing one package.  Result is pass, fail or skip.
+type packageResult struct {
+	Package string
+	Result  string
+	Elapsed float64
+	Output  string
+	Tests   []*testResult
+	Cached  bool
+}
+
+type testResult struct {
+	Name    string
+	Result  string
+	Elapsed float64
+	Output  string
+}
+
+func (p *packageResult) count(result string) int {
+	n := 0
+	for _, t := range p.Tests {
+		if t.Result == result {
+			n++
+		}
+	}
+	return n
+}
+
+//parseTestEvents collects the results of "go test -json" by package.  Lines that are not
+//events (docker gives us stderr too) are ignored.
+func parseTestEvents(output string) map[string]*packageResult {
+	result := make(map[string]*packageResult)
+	tests := make(map[string]*testResult)
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		ev := &testEvent{}
+		if err := json.Unmarshal([]byte(line), ev); err != nil || ev.Package == "" {
+			continue
+		}
+		pkg, ok := result[ev.Package]
+		if !ok {
+			pkg = &packageResult{Package: ev.Package}
+			result[ev.Package] = pkg
+		}
+		if ev.Test == "" {
+			switch ev.Action {
+			case "output":
+				pkg.Output += ev.Output
+			case "pass", "fail", "skip":
+				pkg.Result = ev.Action
+				pkg.Elapsed = ev.Elapsed
+			}
+			continue
+		}
+		key := ev.Package + " " + ev.Test
+		t, ok := tests[key]
+		if !ok {
+			t = &testResult{Name: ev.Test}
+			tests[key] = t
+			pkg.Tests = append(pkg.Tests, t)
+		}
+		switch ev.Action {
+		case "output":
+			t.Output += ev.Output
+		case "pass", "fail", "skip":
+			t.Result = ev.Action
+			t.Elapsed = ev.Elapsed
+		}
+	}
+	return result
+}
+
+//testKey summarizes the source state a package was tested in: its own files, the files of
+//the packages it (and its tests) import, the build settings and the image it ran in.
+func (g *goBuilder) testKey(pkg *goListPackage, image string) string {
+	lines := []string{"image " + image, "settings " + g.settings(), "flags " + strings.Join(g.test.flags, " ")}
+	deps := append(append(append([]string{}, pkg.Deps...), pkg.TestImports...), pkg.XTestImports...)
+	for _, p := range append([]*goListPackage{pkg}, g.source.lookup(deps)...) {
+		for _, file := range p.files() {
+			if host, ok := g.source.hosts[file]; ok {
+				lines = append(lines, host+" "+g.source.digests[host])
+			}
+		}
+	}
+	for _, host := range g.source.extra {
+		lines = append(lines, host+" "+g.source.digests[host])
+	}
+	sort.Strings(lines)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(lines, "\n"))))
+}
+
+//lookup returns the packages with the import paths given that we know about, once each.
+func (s *goSource) lookup(paths []string) []*goListPackage {
+	seen := make(map[string]bool)
+	result := []*goListPackage{}
+	for _, path := range paths {
+		if p, ok := s.packages[path]; ok && !seen[path] && !p.Standard {
+			seen[path] = true
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+//cachedTest is what we keep in etcd about a package that passed.
+type cachedTest struct {
+	Key    string
+	Result *packageResult
+}
+
+func testCacheKey(tag string, pkg string) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, TESTS, tag, pkg)
+}
+
+//withoutPlatform drops GOOS and GOARCH from env.  The tests run in the container, so they
+//are built for its platform, whatever platform the build is for.
+func withoutPlatform(env []string) []string {
+	result := []string{}
+	for _, e := range env {
+		if !strings.HasPrefix(e, "GOOS=") && !strings.HasPrefix(e, "GOARCH=") {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+//runTests tests the packages of the build in image, which was just built.  Packages that
+//passed before with the same source are not tested again.  A summary is printed and the
+//JUnit report written, if there is one; the error is non-nil if any package failed.
+func (g *goBuilder) runTests(conf *Config, image string) error {
+	if g.source == nil {
+		var err error
+		if g.source, err = g.listSource(conf); err != nil {
+			return err
+		}
+	}
+	base := g.runIn.name()
+	if insp, err := conf.cli.InspectImage(base); err == nil {
+		base = insp.ID()
+	}
+
+	names := []string{}
+	for name, pkg := range g.source.packages {
+		if !pkg.DepOnly && !pkg.Standard {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	results := make(map[string]*packageResult)
+	keys := make(map[string]string)
+	toRun := []string{}
+	for _, name := range names {
+		pkg := g.source.packages[name]
+		keys[name] = g.testKey(pkg, base)
+		value, found, err := conf.etcd.Get(testCacheKey(g.tag(), name))
+		if err != nil {
+			return err
+		}
+		cached := &cachedTest{}
+		if found && json.Unmarshal([]byte(value), cached) == nil && cached.Key == keys[name] && cached.Result != nil {
+			cached.Result.Cached = true
+			results[name] = cached.Result
+			continue
+		}
+		toRun = append(toRun, name)
+	}
+
+	if len(toRun) > 0 {
+		rc, err := g.runConfig(conf)
+		if err != nil {
+			return err
+		}
+		rc.Image = image
+		rc.Env = withoutPlatform(rc.Env)
+		cmd := append([]string{"go", "test", "-json"}, g.selectionFlags()...)
+		cmd = append(append(cmd, g.test.flags...), toRun...)
+		flog.Infof("testing %d packages of %s", len(toRun), g.tag())
+		buf, contId, err := conf.cli.CmdRun(rc, cmd...)
+		g.removeContainer(conf, contId)
+		//go test exits non-zero when a test fails, that's only an error if it said nothing
+		ran := parseTestEvents(buf.String())
+		if err != nil && len(ran) == 0 {
+			return fmt.Errorf("unable to test %s: %v", g.tag(), err)
+		}
+		for _, name := range toRun {
+			r, ok := ran[name]
+			if !ok || r.Result == "" {
+				//no result at all means go test itself failed, show what it said
+				r = &packageResult{Package: name, Result: "fail", Output: buf.String()}
+			}
+			results[name] = r
+		}
+	}
+
+	failed := []string{}
+	for _, name := range names {
+		r := results[name]
+		printTestSummary(r)
+		if r.Result == "fail" {
+			failed = append(failed, name)
+			if _, err := conf.etcd.Del(testCacheKey(g.tag(), name)); err != nil {
+				flog.Debugf("no cached result of %s to remove: %v", name, err)
+			}
+			continue
+		}
+		if r.Cached {
+			continue
+		}
+		buf, err := json.Marshal(&cachedTest{Key: keys[name], Result: r})
+		if err != nil {
+			return err
+		}
+		if _, err := conf.etcd.Put(testCacheKey(g.tag(), name), string(buf)); err != nil {
+			return err
+		}
+	}
+
+	if g.test.report != "" {
+		if err := writeJUnit(conf.helper, g.test.report, names, results); err != nil {
+			return fmt.Errorf("unable to write test report %s: %v", g.test.report, err)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tests of %s failed in %s", g.tag(), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+//printTestSummary prints one line for a package, and the output of what failed.
+func printTestSummary(r *packageResult) {
+	switch {
+	case r.Cached:
+		fmt.Printf("[pickett] ok   %-40s (cached)\n", r.Package)
+	case r.Result == "fail":
+		fmt.Printf("[pickett] FAIL %-40s %d of %d failed (%.2fs)\n", r.Package, r.count("fail"), len(r.Tests), r.Elapsed)
+		for _, t := range r.Tests {
+			if t.Result == "fail" {
+				fmt.Print(t.Output)
+			}
+		}
+		if r.count("fail") == 0 {
+			fmt.Print(r.Output)
+		}
+	case len(r.Tests) == 0:
+		fmt.Printf("[pickett] ?    %-40s [no tests]\n", r.Package)
+	default:
+		fmt.Printf("[pickett] ok   %-40s %d passed, %d skipped (%.2fs)\n", r.Package, r.count("pass"), r.count("skip"), r.Elapsed)
+	}
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+	Out      string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	Class   string        `xml:"classname,attr"`
+	Name    string        `xml:"name,attr"`
+	Time    string        `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+	Skipped *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Output  string `xml:",chardata"`
+}
+
+//writeJUnit writes the results as a JUnit XML report, one suite per package.
+func writeJUnit(helper io.Helper, path string, names []string, results map[string]*packageResult) error {
+	report := junitSuites{}
+	for _, name := range names {
+		r := results[name]
+		suite := junitSuite{
+			Name:     name,
+			Tests:    len(r.Tests),
+			Failures: r.count("fail"),
+			Skipped:  r.count("skip"),
+			Time:     fmt.Sprintf("%.3f", r.Elapsed),
+		}
+		if r.Cached {
+			suite.Out = "cached"
+		} else if r.Result == "fail" && suite.Failures == 0 {
+			suite.Out = r.Output
+		}
+		for _, t := range r.Tests {
+			c := junitCase{Class: name, Name: t.Name, Time: fmt.Sprintf("%.3f", t.Elapsed)}
+			switch t.Result {
+			case "fail":
+				c.Failure = &junitFailure{Message: "Failed", Output: t.Output}
+			case "skip":
+				c.Skipped = &struct{}{}
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		report.Suites = append(report.Suites, suite)
+	}
+	buf, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return helper.WriteFileRelative(path, append([]byte(xml.Header), buf...))
+}
@@ -0,0 +1,152 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//HISTORY_LENGTH is how many builds of each tag we remember.
+const HISTORY_LENGTH = 25
+
+//historyRecord is one build of a tag: who built it and when, from what commit of the
+//directory the configuration is in, what image resulted and how long it took.
+type historyRecord struct {
+	User     string
+	Built    time.Time
+	Commit   string
+	ImageID  string
+	Duration time.Duration
+	Reason   string
+}
+
+//historyKey returns the etcd key of the history of tag, or of one build if there is a time.
+func historyKey(tag string, built time.Time) string {
+	if built.IsZero() {
+		return filepath.Join(io.PICKETT_KEYSPACE, HISTORY, tag)
+	}
+	return filepath.Join(io.PICKETT_KEYSPACE, HISTORY, tag, fmt.Sprintf("%020d", built.UnixNano()))
+}
+
+//currentUser is who is building, for the history.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+//recordHistory adds a build of tag to its history, forgetting the oldest builds if there
+//are more than HISTORY_LENGTH.  The history is not important enough to fail a build, so
+//problems are only logged.
+func (c *Config) recordHistory(tag string, started time.Time, took time.Duration, reason string) {
+	rec := &historyRecord{
+		User:     currentUser(),
+		Built:    started,
+		Duration: took,
+		Reason:   reason,
+	}
+	if commit, err := c.helper.GitCommit(); err != nil {
+		flog.Debugf("no commit for the history of %s: %v", tag, err)
+	} else {
+		rec.Commit = commit
+	}
+	if insp, err := c.cli.InspectImage(tag); err == nil {
+		rec.ImageID = insp.ID()
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		flog.Warningf("unable to record the build of %s: %v", tag, err)
+		return
+	}
+	if _, err := c.etcd.Put(historyKey(tag, started), string(buf)); err != nil {
+		flog.Warningf("unable to record the build of %s: %v", tag, err)
+		return
+	}
+	builds, _, err := c.etcd.Children(historyKey(tag, time.Time{}))
+	if err != nil {
+		flog.Debugf("unable to trim the history of %s: %v", tag, err)
+		return
+	}
+	sort.Strings(builds)
+	for len(builds) > HISTORY_LENGTH {
+		if _, err := c.etcd.Del(filepath.Join(historyKey(tag, time.Time{}), builds[0])); err != nil {
+			flog.Debugf("unable to trim the history of %s: %v", tag, err)
+		}
+		builds = builds[1:]
+	}
+}
+
+//loadHistory returns the builds of tag we remember, the most recent first.
+func loadHistory(tag string, etcd io.EtcdClient) ([]*historyRecord, error) {
+	builds, _, err := etcd.Children(historyKey(tag, time.Time{}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(builds)))
+	result := []*historyRecord{}
+	for _, b := range builds {
+		value, present, err := etcd.Get(filepath.Join(historyKey(tag, time.Time{}), b))
+		if err != nil {
+			return nil, err
+		}
+		if !present {
+			continue
+		}
+		rec := &historyRecord{}
+		if err := json.Unmarshal([]byte(value), rec); err != nil {
+			flog.Warningf("ignoring build of %s we can't understand: %v", tag, err)
+			continue
+		}
+		result = append(result, rec)
+	}
+	return result, nil
+}
+
+//CmdHistory shows the builds of tag we remember, the most recent first.
+func CmdHistory(tag string, config *Config) error {
+	if _, ok := config.nameToNode[tag]; !ok {
+		return fmt.Errorf("no such target: %s", tag)
+	}
+	history, err := loadHistory(tag, config.etcd)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		fmt.Printf("[pickett] no builds of %s recorded\n", tag)
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUILT\tUSER\tCOMMIT\tIMAGE\tDURATION\tREASON")
+	for _, rec := range history {
+		commit, image := shortCommit(rec.Commit), strings.TrimPrefix(rec.ImageID, "sha256:")
+		if len(image) > 12 {
+			image = image[:12]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", rec.Built.Format(TIME_FORMAT), rec.User, commit, image,
+			roundDuration(rec.Duration), rec.Reason)
+	}
+	return w.Flush()
+}
+
+//shortCommit shortens a commit from GitCommit to 12 characters, keeping the "+" that marks
+//a tree with changes that are not committed.
+func shortCommit(commit string) string {
+	dirty := strings.HasSuffix(commit, "+")
+	commit = strings.TrimSuffix(commit, "+")
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if dirty {
+		commit += "+"
+	}
+	return commit
+}
@@ -0,0 +1,115 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+// hasPrefix matches strings that start with a prefix, like the keys of the builds of a tag.
+type hasPrefix string
+
+func (h hasPrefix) Matches(x interface{}) bool {
+	s, ok := x.(string)
+	return ok && strings.HasPrefix(s, string(h))
+}
+
+func (h hasPrefix) String() string {
+	return "has prefix " + string(h)
+}
+
+// expectHistory sets up the record of a build of tag in its history, which has no
+// builds before this one.  The image is inspected for the history after the builder's
+// own last look at it, built.
+func expectHistory(controller *gomock.Controller, helper *io.MockHelper, cli *io.MockDockerCli,
+	etcd *io.MockEtcdClient, tag string, imageID string, built *gomock.Call) {
+	helper.EXPECT().GitCommit().Return("0123456789abcdef", nil)
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().ID().Return(imageID)
+	cli.EXPECT().InspectImage(tag).Return(insp, nil).After(built)
+	etcd.EXPECT().Put(hasPrefix("/pickett/history/"+tag+"/"), gomock.Any())
+	etcd.EXPECT().Children("/pickett/history/"+tag).Return([]string{"1"}, true, nil)
+}
+
+func TestHistoryIsTrimmedAndListedNewestFirst(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	helper := io.NewMockHelper(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{helper: helper, cli: cli, etcd: etcd}
+
+	started := time.Now()
+	helper.EXPECT().GitCommit().Return("cafe", nil)
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().ID().Return("newimage")
+	cli.EXPECT().InspectImage(BLETCH).Return(insp, nil)
+	etcd.EXPECT().Put(historyKey(BLETCH, started), gomock.Any()).Do(func(key string, value string) {
+		rec := &historyRecord{}
+		if err := json.Unmarshal([]byte(value), rec); err != nil {
+			t.Fatalf("bad history record: %v", err)
+		}
+		if rec.Commit != "cafe" || rec.ImageID != "newimage" || rec.Duration != time.Minute || rec.Reason != "out of date" {
+			t.Errorf("wrong history record: %+v", rec)
+		}
+	})
+
+	//one too many builds, the oldest goes
+	builds := []string{}
+	for i := HISTORY_LENGTH; i >= 0; i-- {
+		builds = append(builds, fmt.Sprintf("%020d", started.Add(-time.Duration(i)*time.Hour).UnixNano()))
+	}
+	etcd.EXPECT().Children("/pickett/history/"+BLETCH).Return(builds, true, nil)
+	etcd.EXPECT().Del("/pickett/history/"+BLETCH+"/"+builds[0]).Return("", nil)
+
+	c.recordHistory(BLETCH, started, time.Minute, "out of date")
+
+	//listing gives the most recent first
+	etcd.EXPECT().Children("/pickett/history/"+BLETCH).Return([]string{builds[1], builds[2]}, true, nil)
+	etcd.EXPECT().Get("/pickett/history/"+BLETCH+"/"+builds[2]).Return(`{"ImageID":"second"}`, true, nil)
+	etcd.EXPECT().Get("/pickett/history/"+BLETCH+"/"+builds[1]).Return(`{"ImageID":"first"}`, true, nil)
+	history, err := loadHistory(BLETCH, etcd)
+	if err != nil {
+		t.Fatalf("unexpected error loading history: %v", err)
+	}
+	if len(history) != 2 || history[0].ImageID != "second" || history[1].ImageID != "first" {
+		t.Errorf("history in the wrong order: %+v", history)
+	}
+}
+
+func TestTimingsOfABuild(t *testing.T) {
+	timings := buildTimings{}
+	timings.checked("a", "b is out of date", 0)
+	timings.checked("b", "out of date", time.Second)
+	timings.built("b", 2*time.Second)
+	timings.built("a", time.Second)
+	timings.checked("c", "up to date", time.Millisecond)
+
+	all := timings.all()
+	if len(all) != 3 || all[0].Node != "a" || all[1].Node != "b" || all[2].Node != "c" {
+		t.Fatalf("wrong nodes in timings: %+v", all)
+	}
+	if !all[0].Built || all[0].Reason != "b is out of date" || all[1].Build != 2*time.Second || all[2].Built {
+		t.Errorf("wrong timings: %+v", all)
+	}
+}
+
+func TestShortCommit(t *testing.T) {
+	for commit, expected := range map[string]string{
+		"0123456789abcdef":  "0123456789ab",
+		"0123456789abcdef+": "0123456789ab+",
+		"cafe+":             "cafe+",
+		"":                  "",
+	} {
+		if result := shortCommit(commit); result != expected {
+			t.Errorf("expected %s to be shortened to %s, but got %s", commit, expected, result)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package pickett
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	pickett_io "github.com/igneous-systems/pickett/io"
+)
+
+//HostEntry is a docker host that topology nodes can be placed on with their Host.  It is
+//either an Endpoint, given the same way as DOCKER_HOST, with the TLS settings of
+//DOCKER_TLS_VERIFY and DOCKER_CERT_PATH, or a docker Context.  Address is where containers
+//on other hosts reach the ports published on this one; it defaults to the host of the
+//endpoint.  PathMappings say where the docker daemon of the host finds directories of this
+//machine, as the PathMappings of the configuration do for the default host.
+type HostEntry struct {
+	Endpoint     string
+	TLSVerify    bool
+	CertPath     string
+	Context      string
+	Address      string
+	PathMappings []*PathMapping
+}
+
+//endpoint returns the docker endpoint of the host.
+func (h *HostEntry) endpoint() (*pickett_io.DockerEndpoint, error) {
+	if h.Context != "" {
+		if h.Endpoint != "" {
+			return nil, fmt.Errorf("either an Endpoint or a Context, not both")
+		}
+		return pickett_io.ContextEndpoint(h.Context)
+	}
+	if h.Endpoint == "" {
+		return nil, fmt.Errorf("no Endpoint or Context")
+	}
+	return &pickett_io.DockerEndpoint{
+		Host:     h.Endpoint,
+		TLS:      h.TLSVerify || h.CertPath != "",
+		Verify:   h.TLSVerify,
+		CertPath: h.CertPath,
+	}, nil
+}
+
+//dockerHost is the connection to one of the Hosts.
+type dockerHost struct {
+	cli      pickett_io.DockerCli
+	endpoint *pickett_io.DockerEndpoint
+	address  string
+}
+
+//ConnectHosts connects to each of the Hosts, with connect.  Nodes without a Host run on the
+//docker server the config was created with, which is also where everything is built.
+func (c *Config) ConnectHosts(connect func(*pickett_io.DockerEndpoint) (pickett_io.DockerCli, error)) error {
+	c.hosts = make(map[string]*dockerHost)
+	for _, name := range c.hostNames() {
+		if c.Hosts[name] == nil {
+			return fmt.Errorf("host %s has no Endpoint or Context", name)
+		}
+		endpoint, err := c.Hosts[name].endpoint()
+		if err != nil {
+			return fmt.Errorf("host %s: %v", name, err)
+		}
+		cli, err := connect(endpoint)
+		if err != nil {
+			return fmt.Errorf("can't connect to host %s: %v", name, err)
+		}
+		c.hosts[name] = &dockerHost{cli: cli, endpoint: endpoint}
+	}
+	return nil
+}
+
+//hostNames returns the names of the Hosts, in order.
+func (c *Config) hostNames() []string {
+	result := []string{}
+	for name := range c.Hosts {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+//cliOn returns the docker client of the host named, "" is the default one.
+func (c *Config) cliOn(host string) pickett_io.DockerCli {
+	if host == "" {
+		return c.cli
+	}
+	h, ok := c.hosts[host]
+	if !ok {
+		panic(fmt.Sprintf("not connected to host %s", host))
+	}
+	return h.cli
+}
+
+//cliOf returns the docker client of the host r runs on.
+func (c *Config) cliOf(r runner) pickett_io.DockerCli {
+	return c.cliOn(r.host())
+}
+
+//connectedHosts returns the names of the hosts we are connected to, starting with "" for
+//the default one.
+func (c *Config) connectedHosts() []string {
+	result := []string{""}
+	for _, name := range c.hostNames() {
+		if _, ok := c.hosts[name]; ok {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+//dockerClis returns the docker client of each host, the default one first.
+func (c *Config) dockerClis() []pickett_io.DockerCli {
+	result := []pickett_io.DockerCli{}
+	for _, name := range c.connectedHosts() {
+		result = append(result, c.cliOn(name))
+	}
+	return result
+}
+
+//hostAddress returns the IP address containers on other hosts reach the ports published on
+//host at.
+func (c *Config) hostAddress(host string) (string, error) {
+	if host == "" {
+		endpoint, err := pickett_io.DockerEnvironment()
+		if err != nil {
+			return "", err
+		}
+		return pickett_io.EndpointAddress(endpoint.Host)
+	}
+	h, ok := c.hosts[host]
+	if !ok {
+		return "", fmt.Errorf("not connected to host %s", host)
+	}
+	if h.address != "" {
+		return h.address, nil
+	}
+	var err error
+	if entry := c.Hosts[host]; entry.Address != "" {
+		h.address, err = pickett_io.ResolveAddress(entry.Address)
+	} else {
+		h.address, err = pickett_io.EndpointAddress(h.endpoint.Host)
+	}
+	if err != nil {
+		return "", fmt.Errorf("no address for host %s: %v", host, err)
+	}
+	return h.address, nil
+}
+
+//shipImage makes sure the image on host is the one built on the default host, copying it
+//over if it is not.  Images the default host does not have (like the continue images of
+//nodes on host) are left for host to find.
+func (c *Config) shipImage(image string, host string) error {
+	if host == "" {
+		return nil
+	}
+	local, err := c.cli.InspectImage(image)
+	if err != nil {
+		flog.Debugf("not copying %s to host %s, it is not here: %v", image, host, err)
+		return nil
+	}
+	remote := c.cliOn(host)
+	if there, err := remote.InspectImage(image); err == nil && there.ID() == local.ID() {
+		return nil
+	}
+	fmt.Printf("[pickett] copying %s to host %s\n", image, host)
+	tarball, err := c.cli.SaveImage(image)
+	if err != nil {
+		return fmt.Errorf("unable to copy %s to host %s: %v", image, host, err)
+	}
+	defer tarball.Close()
+	if err := remote.LoadImage(tarball); err != nil {
+		return fmt.Errorf("unable to copy %s to host %s: %v", image, host, err)
+	}
+	return nil
+}
+
+//hostLinks splits the links of r (container name to alias, as --link takes them) into the
+//ones to containers on the same host, which stay links, and the ones to containers on
+//other hosts.  Those are reached at the address of their host and the ports they publish
+//there: the alias goes in /etc/hosts, and the environment has the variables a link would
+//set, with the published ports.
+func (c *Config) hostLinks(r runner, topoName string, links map[string]string) (map[string]string, []string, []string, error) {
+	conts := []string{}
+	for cont := range links {
+		conts = append(conts, cont)
+	}
+	sort.Strings(conts)
+	local := make(map[string]string)
+	var extraHosts, env []string
+	for _, cont := range conts {
+		alias := links[cont]
+		info, ok := c.nameToTopology[topoName][alias]
+		if !ok || info.runner.host() == r.host() {
+			local[cont] = alias
+			continue
+		}
+		address, err := c.hostAddress(info.runner.host())
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		insp, err := c.cliOf(info.runner).InspectContainer(cont)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("unable to link %s to %s: %v", r.name(), alias, err)
+		}
+		extraHosts = append(extraHosts, alias+":"+address)
+		vars := linkEnv(alias, address, insp.PortMap())
+		env = append(env, vars...)
+		if len(vars) == 0 {
+			flog.Warningf("%s consumes %s on host %s, which publishes no ports: Expose them to reach it",
+				r.name(), alias, info.runner.host())
+		}
+	}
+	return local, extraHosts, env, nil
+}
+
+//linkEnv returns the variables docker sets for a link to alias, for a container on another
+//host that publishes ports (container port to host bindings, ip:port) at address.
+func linkEnv(alias string, address string, ports map[string][]string) []string {
+	prefix := strings.ToUpper(strings.Replace(alias, "-", "_", -1))
+	names := []string{}
+	for port, bindings := range ports {
+		if len(bindings) > 0 {
+			names = append(names, port)
+		}
+	}
+	sort.Strings(names)
+	result := []string{}
+	for _, port := range names {
+		published := ports[port][0][strings.LastIndex(ports[port][0], ":")+1:]
+		number, proto := port, "tcp"
+		if i := strings.Index(port, "/"); i >= 0 {
+			number, proto = port[:i], port[i+1:]
+		}
+		url := fmt.Sprintf("%s://%s:%s", proto, address, published)
+		name := fmt.Sprintf("%s_PORT_%s_%s", prefix, number, strings.ToUpper(proto))
+		if len(result) == 0 {
+			result = append(result, prefix+"_PORT="+url)
+		}
+		result = append(result,
+			name+"="+url,
+			name+"_ADDR="+address,
+			name+"_PORT="+published,
+			name+"_PROTO="+proto)
+	}
+	return result
+}
@@ -0,0 +1,198 @@
+package pickett
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var hostsExample = `
+// the web server runs on one host, the database it consumes on another
+{
+	"Hosts" : {
+		"front" : { "Endpoint": "tcp://10.0.0.1:2375" },
+		"back" : { "Endpoint": "tcp://10.0.0.2:2375" }
+	},
+	"Topologies" : {
+		"dev" : [
+			{
+				"Name": "web",
+				"RunIn": "web-image",
+				"Host": "front",
+				"Consumes": ["db"]
+			},
+			{
+				"Name": "db",
+				"RunIn": "db-image",
+				"Host": "back",
+				"Expose": {"5432/tcp": 15432}
+			}
+		]
+	}
+}
+`
+
+//linkedByHost matches the run config of a container that reaches what it consumes through
+//the host they are on, not links.
+type linkedByHost struct {
+	extraHosts []string
+	env        []string
+}
+
+func (m linkedByHost) Matches(x interface{}) bool {
+	conf, ok := x.(*io.RunConfig)
+	if !ok {
+		return false
+	}
+	return len(conf.Links) == 0 && fmt.Sprint(conf.ExtraHosts) == fmt.Sprint(m.extraHosts) &&
+		fmt.Sprint(conf.Env) == fmt.Sprint(m.env)
+}
+
+func (m linkedByHost) String() string {
+	return fmt.Sprintf("run config with no links, extra hosts %v and env %v", m.extraHosts, m.env)
+}
+
+func TestNodesOnOtherHosts(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	front := io.NewMockDockerCli(controller)
+	back := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	//the images are here, where they are built, the front already has the one it runs
+	webImage := io.NewMockInspectedImage(controller)
+	webImage.EXPECT().ID().Return("web-id").AnyTimes()
+	dbImage := io.NewMockInspectedImage(controller)
+	dbImage.EXPECT().ID().Return("db-id").AnyTimes()
+	cli.EXPECT().InspectImage("web-image").Return(webImage, nil).Times(2)
+	cli.EXPECT().InspectImage("db-image").Return(dbImage, nil).Times(2)
+	front.EXPECT().InspectImage("web-image").Return(webImage, nil)
+
+	c, err := NewConfig(strings.NewReader(hostsExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	connected := map[string]io.DockerCli{"tcp://10.0.0.1:2375": front, "tcp://10.0.0.2:2375": back}
+	if err := c.ConnectHosts(func(endpoint *io.DockerEndpoint) (io.DockerCli, error) {
+		return connected[endpoint.Host], nil
+	}); err != nil {
+		t.Fatalf("unexpected error connecting: %v", err)
+	}
+
+	for _, node := range []string{"web", "db"} {
+		etcd.EXPECT().Get(recordKey("dev", node, 0)).Return("", false, nil)
+		expectNotInOldLayout(etcd, "dev", node, 0)
+		expectLock(etcd, lockKey("nodes", "dev", node, "0"))
+	}
+
+	//the back does not have the image of the database, so it is copied there
+	back.EXPECT().InspectImage("db-image").Return(nil, errors.New("no such image"))
+	cli.EXPECT().SaveImage("db-image").Return(ioutil.NopCloser(strings.NewReader("tarball")), nil)
+	back.EXPECT().LoadImage(gomock.Any()).Return(nil)
+	back.EXPECT().CmdRun(gomock.Any(), "dev", "0").Return(nil, "dbcont", nil)
+	db := io.NewMockInspectedContainer(controller)
+	db.EXPECT().ContainerID().Return("dbcont")
+	db.EXPECT().ContainerName().Return("db0")
+	db.EXPECT().ImageID().Return("db-id")
+	db.EXPECT().Ip().Return("172.17.0.2")
+	db.EXPECT().PortMap().Return(map[string][]string{"5432/tcp": {"0.0.0.0:15432"}}).Times(2)
+	back.EXPECT().InspectContainer("dbcont").Return(db, nil)
+	back.EXPECT().InspectContainer("db0").Return(db, nil)
+	etcd.EXPECT().Put(recordKey("dev", "db", 0), hasRecord{"db0", "172.17.0.2"}).Return("", nil)
+
+	//the web server reaches it on the back's address and the port it publishes there
+	front.EXPECT().CmdRun(linkedByHost{
+		extraHosts: []string{"db:10.0.0.2"},
+		env: []string{
+			"DB_PORT=tcp://10.0.0.2:15432",
+			"DB_PORT_5432_TCP=tcp://10.0.0.2:15432",
+			"DB_PORT_5432_TCP_ADDR=10.0.0.2",
+			"DB_PORT_5432_TCP_PORT=15432",
+			"DB_PORT_5432_TCP_PROTO=tcp",
+		},
+	}, "dev", "0").Return(nil, "webcont", nil)
+	web := io.NewMockInspectedContainer(controller)
+	web.EXPECT().ContainerID().Return("webcont")
+	web.EXPECT().ContainerName().Return("web0")
+	web.EXPECT().ImageID().Return("web-id")
+	web.EXPECT().Ip().Return("172.17.0.9")
+	web.EXPECT().PortMap().Return(map[string][]string{})
+	front.EXPECT().InspectContainer("webcont").Return(web, nil)
+	etcd.EXPECT().Put(recordKey("dev", "web", 0), hasRecord{"web0", "172.17.0.9"}).Return("", nil)
+
+	if _, err := c.Execute("dev.web", nil); err != nil {
+		t.Fatalf("unexpected error running: %v", err)
+	}
+}
+
+func TestUnknownHost(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	cli.EXPECT().InspectImage("web-image").Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+	config := strings.Replace(hostsExample, `"Host": "front"`, `"Host": "nowhere"`, 1)
+	if _, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), cli, io.NewMockEtcdClient(controller)); err == nil {
+		t.Errorf("expected an error for a node on a host that is not one of the Hosts")
+	}
+}
+
+func TestHostEndpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pickett-contexts")
+	if err != nil {
+		t.Fatalf("can't make a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	//a context, as "docker context create" leaves it
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte("remote")))
+	meta := filepath.Join(dir, "contexts", "meta", id)
+	certs := filepath.Join(dir, "contexts", "tls", id, "docker")
+	for _, d := range []string{meta, certs} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("can't make %s: %v", d, err)
+		}
+	}
+	buf := `{"Name":"remote","Endpoints":{"docker":{"Host":"tcp://10.0.0.3:2376","SkipTLSVerify":false}}}`
+	if err := ioutil.WriteFile(filepath.Join(meta, "meta.json"), []byte(buf), 0644); err != nil {
+		t.Fatalf("can't write the context: %v", err)
+	}
+
+	for _, test := range []struct {
+		entry    HostEntry
+		expected io.DockerEndpoint
+	}{
+		{HostEntry{Endpoint: "unix:///var/run/docker.sock"}, io.DockerEndpoint{Host: "unix:///var/run/docker.sock"}},
+		{HostEntry{Endpoint: "tcp://10.0.0.1:2376", TLSVerify: true, CertPath: "/certs"},
+			io.DockerEndpoint{Host: "tcp://10.0.0.1:2376", TLS: true, Verify: true, CertPath: "/certs"}},
+		{HostEntry{Context: "remote"}, io.DockerEndpoint{Host: "tcp://10.0.0.3:2376", TLS: true, Verify: true, CertPath: certs}},
+	} {
+		endpoint, err := test.entry.endpoint()
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", test.entry, err)
+			continue
+		}
+		if *endpoint != test.expected {
+			t.Errorf("wrong endpoint for %+v: %+v", test.entry, *endpoint)
+		}
+	}
+	for _, bad := range []HostEntry{{}, {Context: "missing"}, {Endpoint: "tcp://10.0.0.1:2375", Context: "remote"}} {
+		if _, err := bad.endpoint(); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+const (
+	//LOCK_TTL is how long (in seconds) a lock outlives a pickett that dies holding it.  The
+	//lock is refreshed well before then for as long as it is held.
+	LOCK_TTL = 60
+	//LOCK_POLL is how often we look again at a lock we are waiting for.
+	LOCK_POLL = 500 * time.Millisecond
+)
+
+//lockHolder is the value of a lock: who holds it, so whoever is waiting can say.
+type lockHolder struct {
+	Holder string
+	Taken  time.Time
+}
+
+//nodeLock is a lock held in etcd, on a topology node or a build.
+type nodeLock struct {
+	key   string
+	value string
+	etcd  io.EtcdClient
+	done  chan struct{}
+}
+
+//lockKey is the etcd key of the lock on what.
+func lockKey(what ...string) string {
+	return filepath.Join(append([]string{io.PICKETT_KEYSPACE, LOCKS}, what...)...)
+}
+
+//whoAmI says who holds a lock, for the messages of those who are waiting for it.
+func whoAmI() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s (pid %d)", currentUser(), host, os.Getpid())
+}
+
+//lock takes the lock on what (a topology node of this project), waiting as long as someone
+//else holds it.  It returns whether we had to wait, since whoever held the lock may have done
+//what we were about to.
+func (c *Config) lock(what ...string) (*nodeLock, bool, error) {
+	return lockIn(c.etcd, what...)
+}
+
+//lockBuild is lock for the build of tag.  Tags belong to the docker server, not to a
+//project, so the lock is shared by every project that builds tag.
+func (c *Config) lockBuild(tag string) (*nodeLock, bool, error) {
+	return lockIn(unscoped(c.etcd), BUILDS, tag)
+}
+
+//lockIn is lock, with the locks kept in etcd.
+func lockIn(etcd io.EtcdClient, what ...string) (*nodeLock, bool, error) {
+	buf, err := json.Marshal(&lockHolder{Holder: whoAmI(), Taken: time.Now()})
+	if err != nil {
+		return nil, false, err
+	}
+	result := &nodeLock{key: lockKey(what...), value: string(buf), etcd: etcd, done: make(chan struct{})}
+	waited := false
+	for {
+		ok, err := etcd.Create(result.key, result.value, LOCK_TTL)
+		if err != nil {
+			return nil, waited, fmt.Errorf("unable to lock %s: %v", filepath.Join(what...), err)
+		}
+		if ok {
+			break
+		}
+		if !waited {
+			holder := &lockHolder{Holder: "someone"}
+			if value, present, err := etcd.Get(result.key); err == nil && present {
+				json.Unmarshal([]byte(value), holder)
+			}
+			fmt.Printf("[pickett] waiting for lock on %s held by %s\n", filepath.Join(what...), holder.Holder)
+			waited = true
+		}
+		time.Sleep(LOCK_POLL)
+	}
+	go result.refresh()
+	return result, waited, nil
+}
+
+//refresh keeps the lock from expiring until it is unlocked.
+func (l *nodeLock) refresh() {
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-time.After(LOCK_TTL * time.Second / 3):
+		}
+		if ok, err := l.etcd.CompareAndSwap(l.key, l.value, l.value, LOCK_TTL); err != nil || !ok {
+			flog.Warningf("unable to keep the lock %s: %v", l.key, err)
+		}
+	}
+}
+
+//unlock gives the lock up, if we still have it.
+func (l *nodeLock) unlock() {
+	close(l.done)
+	if ok, err := l.etcd.CompareAndDelete(l.key, l.value); err != nil || !ok {
+		flog.Warningf("lock %s was lost before it was given up: %v", l.key, err)
+	}
+}
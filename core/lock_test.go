@@ -0,0 +1,64 @@
+package pickett
+
+import (
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//expectLock sets up taking and giving up the lock with the key given, which is free.
+func expectLock(etcd *io.MockEtcdClient, key string) {
+	etcd.EXPECT().Create(key, gomock.Any(), uint64(LOCK_TTL)).Return(true, nil)
+	etcd.EXPECT().CompareAndDelete(key, gomock.Any()).Return(true, nil)
+}
+
+func TestLockWaitsForTheHolder(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{etcd: etcd}
+
+	key := "/pickett/locks/builds/" + BLETCH
+	first := etcd.EXPECT().Create(key, gomock.Any(), uint64(LOCK_TTL)).Return(false, nil)
+	etcd.EXPECT().Get(key).Return(`{"Holder":"someone@elsewhere (pid 1)"}`, true, nil)
+	etcd.EXPECT().Create(key, gomock.Any(), uint64(LOCK_TTL)).Return(true, nil).After(first)
+
+	lock, waited, err := c.lockBuild(BLETCH)
+	if err != nil {
+		t.Fatalf("unexpected error locking: %v", err)
+	}
+	if !waited {
+		t.Errorf("expected to have waited for the lock")
+	}
+	etcd.EXPECT().CompareAndDelete(key, lock.value).Return(true, nil)
+	lock.unlock()
+}
+
+func TestLocksOfBuildsAreShared(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	etcd := io.NewMockEtcdClient(controller)
+	c := &Config{etcd: etcd}
+	if err := c.SetProject("mine", "/home/me/mine/Pickett.json"); err != nil {
+		t.Fatalf("unexpected error setting the project: %v", err)
+	}
+
+	//another checkout building the same tag on the same docker has to wait
+	expectLock(etcd, "/pickett/locks/builds/"+BLETCH)
+	lock, _, err := c.lockBuild(BLETCH)
+	if err != nil {
+		t.Fatalf("unexpected error locking the build: %v", err)
+	}
+	lock.unlock()
+
+	//the nodes of a topology are the project's own
+	expectLock(etcd, "/pickett/projects/mine/locks/nodes/dev/db/0")
+	if lock, _, err = c.lock("nodes", "dev", "db", "0"); err != nil {
+		t.Fatalf("unexpected error locking the node: %v", err)
+	}
+	lock.unlock()
+}
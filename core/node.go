@@ -1,6 +1,7 @@
 package pickett
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/igneous-systems/pickett/io"
@@ -33,6 +34,12 @@ type runner interface {
 	//this returns a map of the results, as containers
 	run(bool, *Config, string, int, *runVolumeSpec) (*policyInput, error)
 
+	//launch is run without starting the consumed runners, the caller supplies the links
+	launch(bool, *Config, string, int, *runVolumeSpec, map[string]string) (*policyInput, error)
+
+	//the runners that must be up before this one can be started
+	consumed() []runner
+
 	//some misc params for the run
 	imageName() string
 	exposed() map[io.Port][]io.PortBinding
@@ -41,6 +48,15 @@ type runner interface {
 	privileged() bool
 	waitFor() bool
 	contName() string
+	stopConfig() io.StopConfig
+	preStopCommand() []string
+
+	//recorded with each instance so we can tell how it was started
+	policyName() string
+	configHash() string
+
+	//one of the Hosts, "" is the default docker host
+	host() string
 
 	//note that this method is not really asking a question of the runner, it's asking a
 	//question about the *image* that the runner executes in
@@ -61,6 +77,7 @@ type node interface {
 	time() time.Time
 	addOut(node) //don't need AddIn because the creator of Node handles that.
 	implementation() builder
+	forget()
 }
 
 //nodeImpl implements the Node interface and has hooks for a builder.  This is the shared
@@ -69,6 +86,7 @@ type nodeImpl struct {
 	b       builder
 	out     []node
 	tagTime time.Time
+	reason  string //why we found the node out of date
 }
 
 //newNodeImpl return a new Node that uses a specific builder implementation.
@@ -93,18 +111,25 @@ func (n *nodeImpl) isOutOfDate(conf *Config) (bool, error) {
 			return false, err
 		}
 		if ood {
+			n.reason = fmt.Sprintf("%s is out of date", in.name())
+			conf.timings.checked(n.name(), n.reason, 0)
 			return true, nil
 		}
 	}
 
 	//I'm not OOD because of recursive calls, so check my specific node type impl
+	start := time.Now()
 	t, ood, err := n.b.ood(conf)
 	if err != nil {
 		return false, err
 	}
-	if !ood {
+	n.reason = "up to date"
+	if ood {
+		n.reason = "out of date"
+	} else {
 		n.tagTime = t
 	}
+	conf.timings.checked(n.name(), n.reason, time.Since(start))
 	return ood, nil
 }
 
@@ -123,12 +148,36 @@ func (n *nodeImpl) build(conf *Config) error {
 			return err
 		}
 	}
-	//there is work to do locally
+	//there is work to do locally, unless someone else did it while we waited for the lock
+	lock, waited, err := conf.lockBuild(n.name())
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	if waited {
+		if t, ood, err := n.b.ood(conf); err == nil && !ood {
+			flog.Infof("'%s' was built while we waited", n.name())
+			n.tagTime = t
+			return nil
+		}
+	}
 	flog.Debugf("Building '%s'", n.name())
+	keep := conf.DockerBuildOptions.Keep
+	previous := ""
+	if keep > 0 {
+		previous = conf.imageID(n.name())
+	}
+	start := time.Now()
 	t, err := n.b.build(conf)
 	if err != nil {
 		return err
 	}
+	took := time.Since(start)
+	if keep > 0 {
+		conf.retain(n.name(), previous, keep)
+	}
+	conf.timings.built(n.name(), took)
+	conf.recordHistory(n.name(), start, took, n.reason)
 	n.tagTime = t
 	return nil
 }
@@ -138,6 +187,15 @@ func (n *nodeImpl) addOut(other node) {
 	n.out = append(n.out, other)
 }
 
+//forget throws away what we found out about this node and the nodes built from it, so the
+//next isOutOfDate checks again.  This is needed when the node's source changes.
+func (n *nodeImpl) forget() {
+	n.tagTime = time.Time{}
+	for _, out := range n.out {
+		out.forget()
+	}
+}
+
 //time returns the time associated with this node (roughly it's last creation time).
 func (n *nodeImpl) time() time.Time {
 	return n.tagTime
@@ -1,32 +1,141 @@
 package pickett
 
 import (
-	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+
+	pickett_io "github.com/igneous-systems/pickett/io"
 )
 
-// needsPathTranslation determines whether we should map from $HOME to /vagrant.
-// Right now, we do so if the transport specified by DOCKER_HOST is TCP.
-func needsPathTranslation() bool {
-	if strings.HasPrefix(os.Getenv("DOCKER_HOST"), "tcp://") {
-		return true
+//PATH_MAPPINGS_ENV names the environment variable with path mappings, as host:daemon pairs
+//separated by commas, like /Users/me:/vagrant,/data:/mnt/data.
+const PATH_MAPPINGS_ENV = "PICKETT_PATH_MAPPINGS"
+
+//PathMapping says that the directory Host on this machine is found at Daemon by the docker
+//daemon, like a VM that mounts the home directory at /vagrant.  Both are absolute.
+type PathMapping struct {
+	Host   string
+	Daemon string
+}
+
+//checkPathMappings puts the mappings of the environment ahead of the PathMappings of the
+//config, so they win for the same Host, and checks that they all make sense.  These are the
+//mappings of the default docker host; each of the Hosts has PathMappings of its own.
+func (c *Config) checkPathMappings() error {
+	env, err := envPathMappings(os.Getenv(PATH_MAPPINGS_ENV))
+	if err != nil {
+		return fmt.Errorf("%s: %v", PATH_MAPPINGS_ENV, err)
+	}
+	c.mappings = make(map[string][]*PathMapping)
+	if c.mappings[""], err = checkedPathMappings(env, c.PathMappings); err != nil {
+		return err
+	}
+	for _, name := range c.hostNames() {
+		if c.Hosts[name] == nil {
+			continue
+		}
+		if c.mappings[name], err = checkedPathMappings(nil, c.Hosts[name].PathMappings); err != nil {
+			return fmt.Errorf("host %s: %v", name, err)
+		}
 	}
-	flog.Debugf("DOCKER_HOST isn't tcp://; assuming it's local and skipping path translation")
-	return false
+	return nil
 }
 
-// translatePath takes a path rooted at $HOME and re-roots it at /vagrant.
-func translatePath(path string) (string, error) {
-	if os.Getenv("HOME") == "" {
-		return "", errors.New("You dont have a HOME environment variable set, can't even guess a vagrant mapping!")
+//checkedPathMappings returns mappings followed by the cleaned up configured ones, which
+//have to be absolute.
+func checkedPathMappings(mappings []*PathMapping, configured []*PathMapping) ([]*PathMapping, error) {
+	result := append([]*PathMapping{}, mappings...)
+	for _, m := range configured {
+		if m == nil || !filepath.IsAbs(m.Host) || !filepath.IsAbs(m.Daemon) {
+			return nil, fmt.Errorf("PathMappings need an absolute Host and Daemon path: %+v", m)
+		}
+		result = append(result, &PathMapping{filepath.Clean(m.Host), filepath.Clean(m.Daemon)})
 	}
-	home := strings.TrimRight(os.Getenv("HOME"), "/") + "/"
-	if !strings.HasPrefix(path, home) {
-		return "", errors.New(fmt.Sprintf("Cant guess a /vagrant mapping from %s", path))
+	return result, nil
+}
+
+//envPathMappings understands the value of PATH_MAPPINGS_ENV, which may be empty.
+func envPathMappings(value string) ([]*PathMapping, error) {
+	result := []*PathMapping{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 || !filepath.IsAbs(parts[0]) || !filepath.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("unable to understand path mapping (%s), should be /host/dir:/daemon/dir", pair)
+		}
+		result = append(result, &PathMapping{filepath.Clean(parts[0]), filepath.Clean(parts[1])})
+	}
+	return result, nil
+}
+
+//daemonPath returns where the docker daemon of host ("" is the default one) finds path,
+//which is on this machine.  The mapping of host with the longest Host that path is in is
+//used, and paths in none of them are the same for the daemon.  Without any mappings, a
+//default docker host reached over tcp without TLS is taken to be a Vagrant VM with $HOME
+//mounted at /vagrant, as pickett always assumed.
+func (c *Config) daemonPath(host string, path string) (string, error) {
+	mappings := c.mappings[host]
+	if len(mappings) == 0 && host == "" {
+		var err error
+		if mappings, err = c.vagrantMappings(); err != nil {
+			return "", err
+		}
+	}
+	clean := filepath.Clean(path)
+	var best *PathMapping
+	for _, m := range mappings {
+		if clean != m.Host && !strings.HasPrefix(clean, strings.TrimRight(m.Host, "/")+"/") {
+			continue
+		}
+		if best == nil || len(m.Host) > len(best.Host) {
+			best = m
+		}
+	}
+	if best == nil {
+		return path, nil
+	}
+	result := filepath.Join(best.Daemon, clean[len(best.Host):])
+	flog.Debugf("path %s is %s for the docker daemon", path, result)
+	return result, nil
+}
+
+//vagrantMappings returns the /vagrant mapping of the default docker host if it is a
+//Vagrant VM, which it is taken to be if it is reached over tcp without TLS.  That is only
+//worked out the first time.
+func (c *Config) vagrantMappings() ([]*PathMapping, error) {
+	c.vagrantOnce.Do(func() {
+		endpoint, err := pickett_io.DockerEnvironment()
+		if err != nil {
+			return
+		}
+		if u, err := url.Parse(endpoint.Host); err != nil || u.Scheme != "tcp" || endpoint.TLS {
+			return
+		}
+		home := os.Getenv("HOME")
+		if home == "" {
+			c.vagrantErr = fmt.Errorf("no HOME to guess a /vagrant mapping from, set PathMappings or %s", PATH_MAPPINGS_ENV)
+			return
+		}
+		c.vagrant = []*PathMapping{{filepath.Clean(home), "/vagrant"}}
+	})
+	return c.vagrant, c.vagrantErr
+}
+
+//daemonVolumes returns volumes (path on this machine to path in the container) with the
+//paths the docker daemon of host finds them at.
+func (c *Config) daemonVolumes(host string, volumes map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+	for k, v := range volumes {
+		dir, err := c.daemonPath(host, k)
+		if err != nil {
+			return nil, err
+		}
+		result[dir] = v
 	}
-	result := "/vagrant/" + path[len(home):]
-	flog.Debugf("no virtualbox mappings, guessing %s -> %s...", path, result)
 	return result, nil
 }
@@ -0,0 +1,170 @@
+package pickett
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var mappingsExample = `
+{
+	"CodeVolumes" : [
+		{ "Directory" : "src", "MountedAt" : "/src" }
+	],
+	"PathMappings" : [
+		{ "Host" : "/home/me", "Daemon" : "/vagrant" },
+		{ "Host" : "/home/me/work", "Daemon" : "/work" },
+		{ "Host" : "/data", "Daemon" : "/mnt/data" }
+	]
+}
+`
+
+//setenv sets the environment variable name for the rest of a test, see restore.
+func setenv(name string, value string) func() {
+	old, had := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if had {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestPathMappings(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	defer setenv(PATH_MAPPINGS_ENV, "/data:/srv/data")()
+
+	helper := io.NewMockHelper(controller)
+	helper.EXPECT().DirectoryRelative("src").Return("/home/me/work/project/src")
+	c, err := NewConfig(strings.NewReader(mappingsExample), helper, io.NewMockDockerCli(controller), nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	for path, expected := range map[string]string{
+		"/home/me/notes":        "/vagrant/notes",
+		"/home/me":              "/vagrant",
+		"/home/me/work/x":       "/work/x",
+		"/home/mere/x":          "/home/mere/x",
+		"/data/set":             "/srv/data/set", //the environment wins over the config
+		"/dev/sdb":              "/dev/sdb",
+		"/home/me/work/../x/y/": "/vagrant/x/y",
+	} {
+		result, err := c.daemonPath("", path)
+		if err != nil {
+			t.Errorf("unexpected error mapping %s: %v", path, err)
+		} else if result != expected {
+			t.Errorf("expected %s to be %s for the daemon, but got %s", path, expected, result)
+		}
+	}
+
+	vols, err := c.codeVolumes()
+	if err != nil {
+		t.Fatalf("unexpected error with the code volumes: %v", err)
+	}
+	if vols["/work/project/src"] != "/src" || len(vols) != 1 {
+		t.Errorf("code volume not mapped: %v", vols)
+	}
+}
+
+func TestVagrantPathMapping(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	defer setenv(PATH_MAPPINGS_ENV, "")()
+	defer setenv("DOCKER_HOST", "tcp://localhost:2375")()
+	defer setenv("DOCKER_TLS_VERIFY", "")()
+	defer setenv("DOCKER_CERT_PATH", "")()
+	defer setenv("HOME", "/home/me")()
+
+	config := `{ "Hosts" : { "remote" : { "Endpoint" : "tcp://10.0.0.2:2375" } } }`
+	c, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), io.NewMockDockerCli(controller), nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	//without mappings, the home directory is in the VM, and the rest is as it is
+	for path, expected := range map[string]string{"/home/me/src": "/vagrant/src", "/dev/sdb": "/dev/sdb"} {
+		if result, err := c.daemonPath("", path); err != nil || result != expected {
+			t.Errorf("expected %s to be %s for the daemon, but got %s (%v)", path, expected, result, err)
+		}
+	}
+	//other hosts are not taken to be the VM
+	if result, err := c.daemonPath("remote", "/home/me/src"); err != nil || result != "/home/me/src" {
+		t.Errorf("expected no mapping for another host, but got %s (%v)", result, err)
+	}
+
+	for env, value := range map[string]string{"DOCKER_HOST": "unix:///var/run/docker.sock", "DOCKER_TLS_VERIFY": "1"} {
+		restore := setenv(env, value)
+		c, err := NewConfig(strings.NewReader("{}"), io.NewMockHelper(controller), io.NewMockDockerCli(controller), nil)
+		if err != nil {
+			t.Fatalf("can't parse legal config file: %v", err)
+		}
+		if result, err := c.daemonPath("", "/home/me/src"); err != nil || result != "/home/me/src" {
+			t.Errorf("expected no mapping with %s=%s, but got %s (%v)", env, value, result, err)
+		}
+		restore()
+	}
+}
+
+func TestHostPathMappings(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	defer setenv(PATH_MAPPINGS_ENV, "/data:/srv/data")()
+
+	config := `{
+	"PathMappings" : [ { "Host" : "/home/me", "Daemon" : "/vagrant" } ],
+	"Hosts" : {
+		"remote" : {
+			"Endpoint" : "tcp://10.0.0.2:2376",
+			"PathMappings" : [ { "Host" : "/home/me", "Daemon" : "/mnt/me" } ]
+		}
+	}
+}`
+	c, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), io.NewMockDockerCli(controller), nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	//the environment is about the default host only
+	for _, m := range []struct{ host, path, expected string }{
+		{"", "/home/me/src", "/vagrant/src"},
+		{"remote", "/home/me/src", "/mnt/me/src"},
+		{"", "/data/set", "/srv/data/set"},
+		{"remote", "/data/set", "/data/set"},
+	} {
+		if result, err := c.daemonPath(m.host, m.path); err != nil || result != m.expected {
+			t.Errorf("expected %s to be %s for the daemon of '%s', but got %s (%v)", m.path, m.expected, m.host, result, err)
+		}
+	}
+	vols, err := c.daemonVolumes("remote", map[string]string{"/home/me/src": "/src"})
+	if err != nil || vols["/mnt/me/src"] != "/src" {
+		t.Errorf("volume not mapped for remote: %v (%v)", vols, err)
+	}
+}
+
+func TestBadPathMappings(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	for _, env := range []string{"/data", "data:/srv/data", "/a:/b:/c"} {
+		restore := setenv(PATH_MAPPINGS_ENV, env)
+		if _, err := NewConfig(strings.NewReader("{}"), io.NewMockHelper(controller), io.NewMockDockerCli(controller), nil); err == nil {
+			t.Errorf("expected an error for path mappings %s", env)
+		}
+		restore()
+	}
+	defer setenv(PATH_MAPPINGS_ENV, "")()
+	for _, config := range []string{
+		`{ "PathMappings" : [ { "Host" : "relative", "Daemon" : "/vagrant" } ] }`,
+		`{ "Hosts" : { "remote" : { "Endpoint" : "tcp://10.0.0.2:2376", "PathMappings" : [ { "Host" : "/home/me", "Daemon" : "mnt" } ] } } }`,
+	} {
+		if _, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), io.NewMockDockerCli(controller), nil); err == nil {
+			t.Errorf("expected an error for a relative path in %s", config)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pickett
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//platformsBuilder is the node for a go build with Platforms.  Each platform is a go build
+//of its own, tagged repo:tag-os-arch, and these are the inputs of this node.  The image
+//of the first platform is also tagged repo:tag, so things that run in or extract from the
+//go build without naming a platform keep working.
+type platformsBuilder struct {
+	repository string
+	tagname    string
+	platforms  []node
+}
+
+//platformTag is the tag of the image of one platform of a go build.
+func platformTag(tag string, goos string, goarch string) string {
+	return tag + "-" + goos + "-" + goarch
+}
+
+//parsePlatform splits a platform like linux/amd64 into GOOS and GOARCH.
+func parsePlatform(platform string) (string, string, error) {
+	parts := strings.Split(strings.Trim(platform, " \n"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("unable to understand platform '%s', expect something like 'linux/amd64'", platform)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (p *platformsBuilder) tag() string {
+	return p.repository + ":" + p.tagname
+}
+
+func (p *platformsBuilder) in() []node {
+	return p.platforms
+}
+
+//ood is only about our own tag, since the node checks the platforms.  We are out of date
+//if repo:tag is not the image of the first platform.
+func (p *platformsBuilder) ood(conf *Config) (time.Time, bool, error) {
+	first, err := conf.cli.InspectImage(p.platforms[0].name())
+	if err != nil {
+		return time.Time{}, true, nil
+	}
+	ours, err := conf.cli.InspectImage(p.tag())
+	if err != nil {
+		flog.Infof("Tagging %s, tag not found.", p.tag())
+		return time.Time{}, true, nil
+	}
+	if ours.ID() != first.ID() {
+		flog.Infof("Tagging %s, not the image of %s.", p.tag(), p.platforms[0].name())
+		return time.Time{}, true, nil
+	}
+	return ours.CreatedTime(), false, nil
+}
+
+//build tags the image of the first platform as repo:tag, the platforms have been built by
+//the time we get here.
+func (p *platformsBuilder) build(conf *Config) (time.Time, error) {
+	first, err := conf.cli.InspectImage(p.platforms[0].name())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed trying to inspect (%s): %v", p.platforms[0].name(), err)
+	}
+	if err := conf.cli.CmdTag(first.ID(), true, &io.TagInfo{Repository: p.repository, Tag: p.tagname}); err != nil {
+		return time.Time{}, fmt.Errorf("failed trying to tag (%s): %v", p.tag(), err)
+	}
+	return first.CreatedTime(), nil
+}
@@ -3,7 +3,6 @@ package pickett
 import (
 	"fmt"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/igneous-systems/pickett/io"
@@ -53,24 +52,44 @@ func formKey(key string, r runner, topoName string, instance int) string {
 	return filepath.Join(io.PICKETT_KEYSPACE, key, topoName, r.name(), fmt.Sprint(instance))
 }
 
-//start runs the runner in its policyInput and records the docker container name into etcd.
+//start runs the runner in its policyInput and records the docker container into etcd.
 //note that this is the lowest level code that knows about the options to docker and etcd.
 //this code is the actual implementation of start.
-func (p *policyInput) start(teeOutput bool, image string, topoName string, instance int, links map[string]string, rv *runVolumeSpec, cli io.DockerCli, etcd io.EtcdClient) error {
+func (p *policyInput) start(teeOutput bool, image string, topoName string, instance int, links map[string]string, rv *runVolumeSpec, conf *Config) error {
 
 	vols := make(map[string]string)
 	if rv != nil {
 		vols[rv.source] = rv.mountAt
 	}
+	vols, err := conf.daemonVolumes(p.r.host(), vols)
+	if err != nil {
+		return err
+	}
+	devices, err := conf.daemonVolumes(p.r.host(), p.r.devices())
+	if err != nil {
+		return err
+	}
+	cli := conf.cliOf(p.r)
+	if err := conf.shipImage(image, p.r.host()); err != nil {
+		return err
+	}
+	links, extraHosts, env, err := conf.hostLinks(p.r, topoName, links)
+	if err != nil {
+		return err
+	}
 	runConfig := &io.RunConfig{
 		Image:      image,
 		Attach:     teeOutput,
 		WaitOutput: teeOutput,
 		Volumes:    vols,
 		Links:      links,
+		ExtraHosts: extraHosts,
+		Env:        env,
 		Ports:      p.r.exposed(),
-		Devices:    p.r.devices(),
+		Devices:    devices,
 		Privileged: p.r.privileged(),
+		Project:    conf.project,
+		Labels:     instanceLabels(topoName, p.r.name(), instance),
 	}
 
 	args := append(p.r.entryPoint(), topoName, fmt.Sprint(instance))
@@ -82,35 +101,56 @@ func (p *policyInput) start(teeOutput bool, image string, topoName string, insta
 	if err != nil {
 		return err
 	}
-	if _, err = etcd.Put(formKey(CONTAINERS, p.r, topoName, instance), insp.ContainerName()); err != nil {
+	rec := newRecord(insp, p.r, time.Now())
+	if err := saveRecord(conf.etcd, topoName, p.r.name(), instance, rec); err != nil {
 		return err
 	}
-	if _, err = etcd.Put(formKey(IPS, p.r, topoName, instance), insp.Ip()); err != nil {
-		return err
+	p.containerName = rec.ContainerName
+	return nil
+}
+
+//stopContainer stops a container that is running r.  If r has a pre-stop command it is run
+//inside the container first; a failure there is reported but does not prevent the stop.
+//A negative timeout means use the one from r's configuration.
+func stopContainer(r runner, cont string, timeout int, cli io.DockerCli) error {
+	if pre := r.preStopCommand(); len(pre) > 0 {
+		flog.Debugf("running pre-stop command %v in %s", pre, cont)
+		out, err := cli.CmdExec(cont, pre...)
+		if err != nil {
+			flog.Warningf("pre-stop command for %s failed, stopping anyway: %v", r.name(), err)
+			if out != nil && out.Len() > 0 {
+				flog.Warningf("%s", out.String())
+			}
+		}
 	}
-	if _, err = etcd.Put(formKey(PORTS, p.r, topoName, instance), strings.Join(insp.Ports(), " ")); err != nil {
-		return err
+	conf := r.stopConfig()
+	if timeout >= 0 {
+		conf.Timeout = uint(timeout)
 	}
-	p.containerName = insp.ContainerName()
-	return nil
+	return cli.CmdStop(cont, &conf)
 }
 
 // stop stops the runner in its policyInput removes the container from etcd.  This is the actual
 // implementation of stop.
 func (p *policyInput) stop(topoName string, instance int, cli io.DockerCli, etcd io.EtcdClient) error {
-	if err := cli.CmdStop(p.containerName); err != nil {
+	if err := stopContainer(p.r, p.containerName, -1, cli); err != nil {
 		return err
 	}
-	if _, err := etcd.Del(formKey(CONTAINERS, p.r, topoName, instance)); err != nil {
-		return err
-	}
-	return nil
+	return deleteRecord(etcd, topoName, p.r.name(), instance)
 }
 
 const (
+	INSTANCES  = "instances"
 	CONTAINERS = "containers"
 	IPS        = "ips"
 	PORTS      = "ports"
+	CONTINUES  = "continues"
+	BUILDS     = "builds"
+	TESTS      = "tests"
+	EXPORTS    = "exports"
+	HISTORY    = "history"
+	PROJECTS   = "projects"
+	LOCKS      = "locks"
 )
 
 func (p stopPolicy) String() string {
@@ -167,26 +207,36 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 			flog.Infof("policy %s is not starting service %s", p, in.r.name())
 			return nil
 		}
+		rebuilt := false
 		if p.rebuildIfOOD && ood {
 			flog.Debugf("policy %s, rebuilding out of date image for '%s'", p, in.r.name())
 			if err := in.r.imageBuild(conf); err != nil {
 				return err
 			}
+			rebuilt = true
+		}
+		img := in.r.imageName()
+		if p.start == CONTINUE {
+			//there is no container, but there may be state committed from an earlier one
+			img, err = p.continueImage(in, false, rebuilt, topoName, instance, conf)
+			if err != nil {
+				return err
+			}
 		}
-		flog.Debugf("policy %s, initial start of %s", p, in.r.name())
-		return in.start(teeOutput, in.r.imageName(), topoName, instance, links, rv, conf.cli, conf.etcd)
+		flog.Debugf("policy %s, initial start of %s from image %s", p, in.r.name(), img)
+		return in.start(teeOutput, img, topoName, instance, links, rv, conf)
 	}
 	//STEP2: stop?
 	if in.isRunning && ood && p.stop == FRESH {
 		flog.Debugf("policy %s, stopping %s (because its out of date)", p, in.r.name())
-		err = in.stop(topoName, instance, conf.cli, conf.etcd)
+		err = in.stop(topoName, instance, conf.cliOf(in.r), conf.etcd)
 		if err != nil {
 			return err
 		}
 		in.isRunning = false
 	} else if in.isRunning && p.stop == ALWAYS {
 		flog.Debugf("policy %s, stopping %s because policy is ALWAYS stop", p, in.r.name())
-		err = in.stop(topoName, instance, conf.cli, conf.etcd)
+		err = in.stop(topoName, instance, conf.cliOf(in.r), conf.etcd)
 		if err != nil {
 			return err
 		}
@@ -194,11 +244,13 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 	}
 	//STEP3: start?
 	if !in.isRunning {
+		rebuilt := false
 		if ood && p.rebuildIfOOD {
 			flog.Debugf("policy %s, rebuilding out of date image for '%s'", p, in.r.name())
 			if err := in.r.imageBuild(conf); err != nil {
 				return err
 			}
+			rebuilt = true
 		}
 
 		var img string
@@ -206,7 +258,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 		if p.start == CONTINUE {
 			//this is the nasty case, need to commit the container and then continue
 			//execution from where it was
-			img, err := conf.cli.CmdCommit(in.containerName, nil)
+			img, err = p.continueImage(in, true, rebuilt, topoName, instance, conf)
 			if err != nil {
 				return err
 			}
@@ -218,7 +270,7 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 			startIt = true
 		}
 		if startIt {
-			if err := in.start(teeOutput, img, topoName, instance, links, rv, conf.cli, conf.etcd); err != nil {
+			if err := in.start(teeOutput, img, topoName, instance, links, rv, conf); err != nil {
 				return err
 			}
 		} else {
@@ -230,26 +282,59 @@ func (p policy) appyPolicy(teeOutput bool, in *policyInput, topoName string, ins
 	return nil
 }
 
+//continueImage returns the image that a CONTINUE instance should be started from.  If the
+//runner's image was just rebuilt, the state built on the old image is thrown away and the
+//instance starts over.  Otherwise the stopped container is committed (if commit is true) and
+//the instance continues from the latest state in its lineage.
+func (p policy) continueImage(in *policyInput, commit bool, rebuilt bool, topoName string, instance int, conf *Config) (string, error) {
+	if rebuilt {
+		flog.Infof("policy %s, image for %s was rebuilt, discarding its continued state", p, in.r.name())
+		//a container the policy has stopped is no longer recorded, but is still there
+		stopped := ""
+		if commit {
+			stopped = in.containerName
+		}
+		if err := resetContinuation(in.r, topoName, instance, stopped, conf); err != nil {
+			return "", err
+		}
+		return in.r.imageName(), nil
+	}
+	if commit {
+		img, err := commitContinuation(in, topoName, instance, conf)
+		if err == nil {
+			return img, nil
+		}
+		flog.Warningf("policy %s, unable to commit %s (%s), continuing from its last committed state: %v",
+			p, in.r.name(), in.containerName, err)
+	}
+	lineage, err := loadContinuation(in.r, topoName, instance, conf)
+	if err != nil {
+		return "", err
+	}
+	if lineage == nil || lineage.latest() == "" {
+		return in.r.imageName(), nil
+	}
+	return lineage.latest(), nil
+}
+
 //createPolicyInput does the work of interrogating etcd and if necessary docker to figure
 //out the state of services.  It returns a policyInput suitable for applying policy to.
 func createPolicyInput(r runner, topoName string, instance int, conf *Config) (*policyInput, error) {
-	key := formKey(CONTAINERS, r, topoName, instance)
-	value, present, err := conf.etcd.Get(key)
+	rec, present, err := loadRecord(conf.etcd, topoName, r.name(), instance)
 	if err != nil {
 		return nil, err
 	}
 	result := &policyInput{
-		hasStarted:    present,
-		containerName: value,
-		r:             r,
+		hasStarted: present,
+		r:          r,
 	}
 	if present {
-		insp, err := conf.cli.InspectContainer(value)
+		result.containerName = rec.ContainerName
+		insp, err := conf.cliOf(r).InspectContainer(rec.ContainerName)
 		if err != nil {
-			flog.Debugf("ignoring docker container %s that is AWOL, probably was manually killed... %s", value, err)
+			flog.Debugf("ignoring docker container %s that is AWOL, probably was manually killed... %s", rec.ContainerName, err)
 			//delete the offending container
-			_, err = conf.etcd.Del(formKey(CONTAINERS, r, topoName, instance))
-			if err != nil {
+			if err := deleteRecord(conf.etcd, topoName, r.name(), instance); err != nil {
 				return nil, err
 			}
 			result.isRunning = false
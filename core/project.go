@@ -0,0 +1,205 @@
+package pickett
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//notInProjectID is what can't be part of a project ID, which is used in labels.
+var notInProjectID = regexp.MustCompile("[^a-z0-9_.-]+")
+
+//ProjectID returns the ID of the project of a configuration file: the name of the
+//directory it is in and a digest of its full path, so two checkouts of the same project
+//are different projects.
+func ProjectID(configFile string) string {
+	abs, err := filepath.Abs(configFile)
+	if err != nil {
+		abs = configFile
+	}
+	name := strings.Trim(notInProjectID.ReplaceAllString(strings.ToLower(filepath.Base(filepath.Dir(abs))), "-"), "-")
+	if name == "" {
+		name = "pickett"
+	}
+	sum := sha1.Sum([]byte(abs))
+	return fmt.Sprintf("%s-%x", name, sum[:4])
+}
+
+//projectIDs are the project IDs that can be given, in the configuration or on the command line.
+var projectIDs = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+//SetProject chooses the project we work on: the one given (with --project), or else the
+//Project of the configuration, or else the one of the configuration file.  What pickett
+//knows about the project is kept under its own part of the etcd keyspace, and the
+//containers and images we create are labelled with it.
+func (c *Config) SetProject(given string, configFile string) error {
+	id := given
+	if id == "" {
+		id = c.Project
+	}
+	if id == "" {
+		id = ProjectID(configFile)
+	}
+	if !projectIDs.MatchString(id) {
+		return fmt.Errorf("bad project %s, use letters, digits, '_', '.' and '-'", id)
+	}
+	c.project = id
+	c.etcd = &projectStore{unscoped(c.etcd), id}
+	return nil
+}
+
+//unscoped returns etcd as it is, not as a project sees it.
+func unscoped(etcd io.EtcdClient) io.EtcdClient {
+	if store, ok := etcd.(*projectStore); ok {
+		return store.EtcdClient
+	}
+	return etcd
+}
+
+//projectKeyspace is where what pickett knows about a project is kept in etcd.
+func projectKeyspace(project string) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, PROJECTS, project)
+}
+
+//projectStore is etcd as one project sees it: keys in the pickett keyspace are in the
+//keyspace of the project instead, so /pickett/builds/foo is really
+///pickett/projects/<project>/builds/foo.  Other keys are left alone.
+type projectStore struct {
+	io.EtcdClient
+	project string
+}
+
+func (p *projectStore) key(path string) string {
+	root := filepath.Clean(io.PICKETT_KEYSPACE)
+	clean := filepath.Clean(path)
+	if clean != root && !strings.HasPrefix(clean, root+"/") {
+		return path
+	}
+	return filepath.Join(projectKeyspace(p.project), strings.TrimPrefix(clean, root))
+}
+
+func (p *projectStore) Get(path string) (string, bool, error) {
+	return p.EtcdClient.Get(p.key(path))
+}
+
+func (p *projectStore) Put(path string, value string) (string, error) {
+	return p.EtcdClient.Put(p.key(path), value)
+}
+
+func (p *projectStore) Del(path string) (string, error) {
+	return p.EtcdClient.Del(p.key(path))
+}
+
+func (p *projectStore) Children(path string) ([]string, bool, error) {
+	return p.EtcdClient.Children(p.key(path))
+}
+
+func (p *projectStore) RecursiveDel(path string) (string, error) {
+	return p.EtcdClient.RecursiveDel(p.key(path))
+}
+
+func (p *projectStore) Create(path string, value string, ttl uint64) (bool, error) {
+	return p.EtcdClient.Create(p.key(path), value, ttl)
+}
+
+func (p *projectStore) CompareAndSwap(path string, value string, prev string, ttl uint64) (bool, error) {
+	return p.EtcdClient.CompareAndSwap(p.key(path), value, prev, ttl)
+}
+
+func (p *projectStore) CompareAndDelete(path string, prev string) (bool, error) {
+	return p.EtcdClient.CompareAndDelete(p.key(path), prev)
+}
+
+//LEGACY_ADOPTED is the key, in the keyspace of a project, that says the project has taken
+//over the state it had before projects had their own keyspace.
+const LEGACY_ADOPTED = "adopted"
+
+//AdoptLegacyState moves what pickett knew before projects had their own keyspace, directly
+//under /pickett, into the keyspace of the project: the instances of its topologies, their
+//continue lineages and the records of its builds.  Only the names the configuration has are
+//moved, the rest is left for the projects they belong to.  It is done once per project;
+//state the project already has is not overwritten.
+func (c *Config) AdoptLegacyState() error {
+	store, ok := c.etcd.(*projectStore)
+	if !ok {
+		return nil
+	}
+	raw := store.EtcdClient
+	done := filepath.Join(projectKeyspace(c.project), LEGACY_ADOPTED)
+	if _, present, err := raw.Get(done); err != nil || present {
+		return err
+	}
+	top, _, err := raw.Children(filepath.Clean(io.PICKETT_KEYSPACE))
+	if err != nil {
+		return err
+	}
+	legacy := make(map[string]bool)
+	for _, kind := range top {
+		legacy[kind] = true
+	}
+
+	keys := []string{}
+	for _, kind := range []string{INSTANCES, CONTAINERS, IPS, PORTS, CONTINUES} {
+		if !legacy[kind] {
+			continue
+		}
+		for topoName, tmap := range c.nameToTopology {
+			for nodeName := range tmap {
+				keys = append(keys, filepath.Join(io.PICKETT_KEYSPACE, kind, topoName, nodeName))
+			}
+		}
+	}
+	for _, kind := range []string{BUILDS, TESTS, EXPORTS, HISTORY} {
+		if !legacy[kind] {
+			continue
+		}
+		for tag := range c.nameToNode {
+			keys = append(keys, filepath.Join(io.PICKETT_KEYSPACE, kind, tag))
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := adoptTree(raw, store.key(key), key); err != nil {
+			return fmt.Errorf("unable to move %s into project %s: %v", key, c.project, err)
+		}
+	}
+	_, err = raw.Put(done, "true")
+	return err
+}
+
+//adoptTree moves the keys under from to the same place under to, unless they are there
+//already.
+func adoptTree(raw io.EtcdClient, to string, from string) error {
+	children, found, err := raw.Children(from)
+	if err != nil || !found {
+		return err
+	}
+	if len(children) > 0 {
+		for _, child := range children {
+			if err := adoptTree(raw, filepath.Join(to, child), filepath.Join(from, child)); err != nil {
+				return err
+			}
+		}
+		_, err := raw.RecursiveDel(from)
+		return err
+	}
+	value, present, err := raw.Get(from)
+	if err != nil || !present {
+		return err
+	}
+	if _, there, err := raw.Get(to); err != nil {
+		return err
+	} else if !there && value != "" {
+		flog.Debugf("moving %s to %s", from, to)
+		if _, err := raw.Put(to, value); err != nil {
+			return err
+		}
+	}
+	_, err = raw.Del(from)
+	return err
+}
@@ -0,0 +1,271 @@
+package pickett
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//The containers of topology nodes are labelled with the instance they run, so that an
+//instance whose record was lost can be found again.
+const (
+	TOPOLOGY_LABEL = "pickett.topology"
+	NODE_LABEL     = "pickett.node"
+	INSTANCE_LABEL = "pickett.instance"
+)
+
+//instanceLabels are the labels of the container of an instance.
+func instanceLabels(topoName string, nodeName string, instance int) map[string]string {
+	return map[string]string{
+		TOPOLOGY_LABEL: topoName,
+		NODE_LABEL:     nodeName,
+		INSTANCE_LABEL: fmt.Sprint(instance),
+	}
+}
+
+//dockerContainers is what docker has, by ID and by name, to look up what etcd says we have.
+type dockerContainers map[string]*io.ContainerInfo
+
+func newDockerContainers(all []*io.ContainerInfo) dockerContainers {
+	result := make(dockerContainers)
+	for _, cont := range all {
+		result[cont.ID] = cont
+		for _, name := range cont.Names {
+			result[strings.TrimPrefix(name, "/")] = cont
+		}
+	}
+	return result
+}
+
+//has returns whether the container of rec is still there, running or not.
+func (d dockerContainers) has(rec *instanceRecord) bool {
+	if rec.ContainerID != "" {
+		if _, ok := d[rec.ContainerID]; ok {
+			return true
+		}
+	}
+	_, ok := d[strings.TrimPrefix(rec.ContainerName, "/")]
+	return ok
+}
+
+//orphans returns the labelled containers of this project on host, by topology node and
+//instance, that are candidates for adoption.  When more than one claims an instance, the
+//newest wins.
+func (c *Config) orphans(host string, all []*io.ContainerInfo) map[string]map[int]*io.ContainerInfo {
+	result := make(map[string]map[int]*io.ContainerInfo)
+	for _, cont := range all {
+		if cont.Labels[io.PROJECT_LABEL] != c.project {
+			continue
+		}
+		topoName, nodeName := cont.Labels[TOPOLOGY_LABEL], cont.Labels[NODE_LABEL]
+		instance, err := strconv.Atoi(cont.Labels[INSTANCE_LABEL])
+		if topoName == "" || nodeName == "" || err != nil {
+			continue
+		}
+		if info, ok := c.nameToTopology[topoName][nodeName]; !ok || info.runner.host() != host {
+			continue
+		}
+		key := topoName + "." + nodeName
+		if result[key] == nil {
+			result[key] = make(map[int]*io.ContainerInfo)
+		}
+		if prev, ok := result[key][instance]; ok && prev.Created >= cont.Created {
+			continue
+		}
+		result[key][instance] = cont
+	}
+	return result
+}
+
+//reconcile makes what etcd says about the instances of each topology node agree with the
+//containers docker has, on the host of the node.  Records of containers that are gone are
+//dropped, along with keys the old layout left behind.  Records are only changed holding the
+//lock on their instance, as a run does.  If adopt is set, labelled containers of this project with no
+//record are given one.  It returns a description of each change.
+func (c *Config) reconcile(adopt bool) ([]string, error) {
+	containers := make(map[string]dockerContainers)
+	orphans := make(map[string]map[int]*io.ContainerInfo)
+	for _, host := range c.connectedHosts() {
+		all, err := c.cliOn(host).ListAllContainers()
+		if err != nil {
+			return nil, err
+		}
+		containers[host] = newDockerContainers(all)
+		for runnable, found := range c.orphans(host, all) {
+			orphans[runnable] = found
+		}
+	}
+	changes := []string{}
+
+	_, runnables := c.EntryPoints()
+	sort.Strings(runnables)
+	for _, runnable := range runnables {
+		pair := strings.Split(runnable, ".")
+		topoName, nodeName := pair[0], pair[1]
+		r := c.nameToTopology[topoName][nodeName].runner
+		if _, ok := containers[r.host()]; !ok {
+			continue
+		}
+		records, err := recordedInstances(c.etcd, topoName, nodeName)
+		if err != nil {
+			return changes, err
+		}
+		for _, i := range sortedInstances(records) {
+			rec := records[i]
+			if containers[r.host()].has(rec) {
+				continue
+			}
+			dropped, err := c.dropIfGone(r, topoName, i)
+			if err != nil {
+				return changes, err
+			}
+			if dropped == nil {
+				continue
+			}
+			delete(records, i)
+			changes = append(changes, fmt.Sprintf("dropped %s.%s[%d], container %s is gone", topoName, nodeName, i, dropped.ContainerName))
+		}
+		for _, kind := range []string{IPS, PORTS} {
+			children, found, err := c.etcd.Children(filepath.Join(io.PICKETT_KEYSPACE, kind, topoName, nodeName))
+			if err != nil {
+				return changes, err
+			}
+			if !found {
+				continue
+			}
+			for _, child := range children {
+				i, err := strconv.Atoi(child)
+				if err != nil {
+					continue
+				}
+				if _, ok := records[i]; ok {
+					continue
+				}
+				if _, err := c.etcd.Del(oldKey(kind, topoName, nodeName, i)); err != nil {
+					return changes, err
+				}
+				changes = append(changes, fmt.Sprintf("dropped leftover %s key of %s.%s[%d]", kind, topoName, nodeName, i))
+			}
+		}
+		if !adopt {
+			continue
+		}
+		found := orphans[runnable]
+		numbers := []int{}
+		for i := range found {
+			numbers = append(numbers, i)
+		}
+		sort.Ints(numbers)
+		for _, i := range numbers {
+			if _, ok := records[i]; ok {
+				continue
+			}
+			rec, err := c.adopt(r, topoName, i, found[i].ID)
+			if err != nil {
+				return changes, err
+			}
+			if rec != nil {
+				changes = append(changes, fmt.Sprintf("adopted container %s as %s.%s[%d]", rec.ContainerName, topoName, nodeName, i))
+			}
+		}
+	}
+	return changes, nil
+}
+
+//dropIfGone drops the record of instance i of r if docker confirms its container is gone,
+//and returns it.  Any other error inspecting the container is returned.  The container docker listed may be gone because a pickett that is running
+//the node just replaced it, so this is done holding the lock on the instance, with the record
+//as it is then.  It returns nil if the record is kept.
+func (c *Config) dropIfGone(r runner, topoName string, i int) (*instanceRecord, error) {
+	lock, _, err := c.lock("nodes", topoName, r.name(), fmt.Sprint(i))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	rec, present, err := loadRecord(c.etcd, topoName, r.name(), i)
+	if err != nil || !present {
+		return nil, err
+	}
+	cont := rec.ContainerID
+	if cont == "" {
+		cont = rec.ContainerName
+	}
+	if _, err := c.cliOf(r).InspectContainer(cont); err == nil {
+		flog.Debugf("keeping %s.%s[%d], container %s is there after all", topoName, r.name(), i, cont)
+		return nil, nil
+	} else if !io.IsNoSuchContainer(err) {
+		//docker may just be unreachable, that's no reason to forget the container
+		return nil, fmt.Errorf("unable to check container %s of %s.%s[%d]: %v", cont, topoName, r.name(), i, err)
+	}
+	if err := deleteRecord(c.etcd, topoName, r.name(), i); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+//adopt records the container id as instance i of r, unless the instance has a record by the
+//time we hold its lock.  It returns the new record, or nil if nothing was adopted.
+func (c *Config) adopt(r runner, topoName string, i int, id string) (*instanceRecord, error) {
+	lock, _, err := c.lock("nodes", topoName, r.name(), fmt.Sprint(i))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+	if _, present, err := loadRecord(c.etcd, topoName, r.name(), i); err != nil || present {
+		return nil, err
+	}
+	insp, err := c.cliOf(r).InspectContainer(id)
+	if err != nil {
+		flog.Warningf("not adopting container %s: %v", id, err)
+		return nil, nil
+	}
+	rec := newRecord(insp, r, insp.CreatedTime())
+	if err := saveRecord(c.etcd, topoName, r.name(), i, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+//sortedInstances returns the instance numbers of records, in order.
+func sortedInstances(records map[int]*instanceRecord) []int {
+	result := []int{}
+	for i := range records {
+		result = append(result, i)
+	}
+	sort.Ints(result)
+	return result
+}
+
+//LightReconcile drops the records of containers that are gone, and what the old layout
+//left behind, before a command looks at them.  It does not adopt anything, and a failure
+//is only a warning: the command can still go ahead.
+func (c *Config) LightReconcile() {
+	changes, err := c.reconcile(false)
+	for _, change := range changes {
+		flog.Infof("%s", change)
+	}
+	if err != nil {
+		flog.Warningf("unable to reconcile etcd with docker: %v", err)
+	}
+}
+
+//CmdReconcile makes etcd agree with docker about the instances of the topologies: records
+//of containers that are gone are dropped, and labelled containers that etcd does not know
+//about are adopted.  Each change is reported.
+func CmdReconcile(config *Config) error {
+	changes, err := config.reconcile(true)
+	for _, change := range changes {
+		fmt.Printf("[pickett] %s\n", change)
+	}
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Println("[pickett] etcd and docker agree, nothing to do")
+	}
+	return nil
+}
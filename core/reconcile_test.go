@@ -0,0 +1,122 @@
+package pickett
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+var reconcileExample = `
+{
+	"Topologies" : {
+		"dev" : [
+			{
+				"Name": "db",
+				"RunIn": "db-image",
+				"Instances": 3
+			}
+		]
+	}
+}
+`
+
+func TestReconcileDropsDeadAndAdoptsLost(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	cli.EXPECT().InspectImage("db-image").Return(io.NewMockInspectedImage(controller), nil)
+	c, err := NewConfig(strings.NewReader(reconcileExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	//db0 is alive, db2 was removed by hand, and two containers claim to be instance 1 (the
+	//newer is adopted) but etcd does not know about either
+	labels := instanceLabels("dev", "db", 1)
+	cli.EXPECT().ListAllContainers().Return([]*io.ContainerInfo{
+		{ID: "cont0", Names: []string{"/db0"}},
+		{ID: "older", Names: []string{"/db1-old"}, Created: 100, Labels: labels},
+		{ID: "newer", Names: []string{"/db1"}, Created: 200, Labels: labels},
+	}, nil)
+
+	etcd.EXPECT().Children("/pickett/instances/dev/db").Return([]string{"0", "2", "4"}, true, nil)
+	etcd.EXPECT().Children("/pickett/containers/dev/db").Return(nil, false, nil)
+	etcd.EXPECT().Get(recordKey("dev", "db", 0)).Return(`{"ContainerName":"db0"}`, true, nil)
+	expectLock(etcd, lockKey("nodes", "dev", "db", "2"))
+	etcd.EXPECT().Get(recordKey("dev", "db", 2)).Return(`{"ContainerID":"cont2","ContainerName":"db2"}`, true, nil).Times(2)
+	cli.EXPECT().InspectContainer("cont2").Return(nil, &docker.NoSuchContainer{ID: "cont2"})
+	etcd.EXPECT().Del(recordKey("dev", "db", 2)).Return("", nil)
+
+	//a run replaced the container of instance 4 after docker was asked, so it stays
+	expectLock(etcd, lockKey("nodes", "dev", "db", "4"))
+	first := etcd.EXPECT().Get(recordKey("dev", "db", 4)).Return(`{"ContainerID":"old4","ContainerName":"db4"}`, true, nil)
+	etcd.EXPECT().Get(recordKey("dev", "db", 4)).Return(`{"ContainerID":"new4","ContainerName":"db4"}`, true, nil).After(first)
+	cli.EXPECT().InspectContainer("new4").Return(io.NewMockInspectedContainer(controller), nil)
+
+	//instance 3 left its ip behind in the old layout
+	etcd.EXPECT().Children("/pickett/ips/dev/db").Return([]string{"0", "3"}, true, nil)
+	etcd.EXPECT().Del("/pickett/ips/dev/db/3").Return("", nil)
+	etcd.EXPECT().Children("/pickett/ports/dev/db").Return(nil, false, nil)
+
+	expectLock(etcd, lockKey("nodes", "dev", "db", "1"))
+	etcd.EXPECT().Get(recordKey("dev", "db", 1)).Return("", false, nil)
+	expectNotInOldLayout(etcd, "dev", "db", 1)
+	adopted := io.NewMockInspectedContainer(controller)
+	adopted.EXPECT().ContainerID().Return("newer")
+	adopted.EXPECT().ContainerName().Return("db1")
+	adopted.EXPECT().ImageID().Return("db-image-id")
+	adopted.EXPECT().Ip().Return("10.0.0.1")
+	adopted.EXPECT().PortMap().Return(map[string][]string{})
+	adopted.EXPECT().CreatedTime().Return(time.Now())
+	cli.EXPECT().InspectContainer("newer").Return(adopted, nil)
+	etcd.EXPECT().Put(recordKey("dev", "db", 1), hasRecord{"db1", "10.0.0.1"}).Return("", nil)
+
+	changes, err := c.reconcile(true)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	expected := []string{
+		"dropped dev.db[2], container db2 is gone",
+		"dropped leftover ips key of dev.db[3]",
+		"adopted container db1 as dev.db[1]",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong changes reported: %v", changes)
+	}
+}
+
+func TestReconcileKeepsRecordsDockerCantCheck(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	cli.EXPECT().InspectImage("db-image").Return(io.NewMockInspectedImage(controller), nil)
+	c, err := NewConfig(strings.NewReader(reconcileExample), helper, cli, etcd)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	//docker failing to answer is not docker saying the container is gone
+	cli.EXPECT().ListAllContainers().Return([]*io.ContainerInfo{}, nil)
+	etcd.EXPECT().Children("/pickett/instances/dev/db").Return([]string{"0"}, true, nil)
+	etcd.EXPECT().Children("/pickett/containers/dev/db").Return(nil, false, nil)
+	expectLock(etcd, lockKey("nodes", "dev", "db", "0"))
+	etcd.EXPECT().Get(recordKey("dev", "db", 0)).Return(`{"ContainerID":"cont0","ContainerName":"db0"}`, true, nil).Times(2)
+	cli.EXPECT().InspectContainer("cont0").Return(nil, &docker.Error{Status: 500, Message: "server error"})
+
+	if _, err := c.reconcile(false); err == nil {
+		t.Errorf("expected an error when docker can't say if the container is there")
+	}
+}
@@ -0,0 +1,284 @@
+package pickett
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//instanceRecord is what pickett knows about one instance of a topology node that it started.
+//It is stored in etcd as a single json value, so it is written and deleted in one operation.
+type instanceRecord struct {
+	ContainerID   string
+	ContainerName string
+	ImageID       string
+	Started       time.Time
+	IP            string
+	Ports         map[string][]string //container port to host bindings (ip:port)
+	Policy        string
+	ConfigHash    string
+}
+
+//newRecord is the record of an instance of r that runs in the container inspected.
+func newRecord(insp io.InspectedContainer, r runner, started time.Time) *instanceRecord {
+	return &instanceRecord{
+		ContainerID:   insp.ContainerID(),
+		ContainerName: insp.ContainerName(),
+		ImageID:       insp.ImageID(),
+		Started:       started,
+		IP:            insp.Ip(),
+		Ports:         insp.PortMap(),
+		Policy:        r.policyName(),
+		ConfigHash:    r.configHash(),
+	}
+}
+
+//recordKey returns the etcd key of the record of an instance.
+func recordKey(topoName string, nodeName string, instance int) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, INSTANCES, topoName, nodeName, fmt.Sprint(instance))
+}
+
+//oldKey returns the key an instance used in the layout from before records, when the
+//container name, ip and ports were separate keys.
+func oldKey(kind string, topoName string, nodeName string, instance int) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, kind, topoName, nodeName, fmt.Sprint(instance))
+}
+
+//saveRecord writes the record of an instance, replacing any previous one.
+func saveRecord(etcd io.EtcdClient, topoName string, nodeName string, instance int, rec *instanceRecord) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = etcd.Put(recordKey(topoName, nodeName, instance), string(buf))
+	return err
+}
+
+//deleteRecord removes the record of an instance.
+func deleteRecord(etcd io.EtcdClient, topoName string, nodeName string, instance int) error {
+	_, err := etcd.Del(recordKey(topoName, nodeName, instance))
+	return err
+}
+
+//loadRecord reads the record of an instance.  If there is no record but the instance is
+//known in the old layout, it is migrated: the record is built from the old keys, written,
+//and the old keys removed.
+func loadRecord(etcd io.EtcdClient, topoName string, nodeName string, instance int) (*instanceRecord, bool, error) {
+	value, present, err := etcd.Get(recordKey(topoName, nodeName, instance))
+	if err != nil {
+		return nil, false, err
+	}
+	if present {
+		rec := &instanceRecord{}
+		if err := json.Unmarshal([]byte(value), rec); err != nil {
+			return nil, false, fmt.Errorf("can't understand record of %s.%s[%d]: %v", topoName, nodeName, instance, err)
+		}
+		return rec, true, nil
+	}
+	return migrateRecord(etcd, topoName, nodeName, instance)
+}
+
+//migrateRecord converts an instance from the old layout to a record.  Ports are given a
+//protocol, like 80/tcp, as docker names them in the records made since.  IPS and PORTS keys of an instance whose
+//container is not known are dropped, they are all that is left of it.
+func migrateRecord(etcd io.EtcdClient, topoName string, nodeName string, instance int) (*instanceRecord, bool, error) {
+	cont, present, err := etcd.Get(oldKey(CONTAINERS, topoName, nodeName, instance))
+	if err != nil {
+		return nil, false, err
+	}
+	ip, foundIP, err := etcd.Get(oldKey(IPS, topoName, nodeName, instance))
+	if err != nil {
+		return nil, false, err
+	}
+	ports, foundPorts, err := etcd.Get(oldKey(PORTS, topoName, nodeName, instance))
+	if err != nil {
+		return nil, false, err
+	}
+	if !present {
+		if foundIP || foundPorts {
+			flog.Debugf("dropping what the old layout left of %s.%s[%d]", topoName, nodeName, instance)
+			dropOldKeys(etcd, topoName, nodeName, instance, foundIP, foundPorts)
+		}
+		return nil, false, nil
+	}
+	flog.Debugf("migrating %s.%s[%d] to a single record", topoName, nodeName, instance)
+	rec := &instanceRecord{
+		ContainerName: cont,
+		IP:            ip,
+		Ports:         make(map[string][]string),
+	}
+	for _, p := range strings.Fields(ports) {
+		if !strings.Contains(p, "/") {
+			p += "/tcp"
+		}
+		rec.Ports[p] = []string{}
+	}
+	if err := saveRecord(etcd, topoName, nodeName, instance, rec); err != nil {
+		return nil, false, err
+	}
+	if _, err := etcd.Del(oldKey(CONTAINERS, topoName, nodeName, instance)); err != nil {
+		flog.Debugf("ignoring failure to delete old %s key of %s.%s[%d]: %v", CONTAINERS, topoName, nodeName, instance, err)
+	}
+	dropOldKeys(etcd, topoName, nodeName, instance, foundIP, foundPorts)
+	return rec, true, nil
+}
+
+//dropOldKeys removes the IPS and PORTS keys of an instance in the old layout, the ones
+//that are there.
+func dropOldKeys(etcd io.EtcdClient, topoName string, nodeName string, instance int, ip bool, ports bool) {
+	for kind, there := range map[string]bool{IPS: ip, PORTS: ports} {
+		if !there {
+			continue
+		}
+		if _, err := etcd.Del(oldKey(kind, topoName, nodeName, instance)); err != nil {
+			flog.Debugf("ignoring failure to delete old %s key of %s.%s[%d]: %v", kind, topoName, nodeName, instance, err)
+		}
+	}
+}
+
+//recordedInstances returns the records of every instance of a node, in either layout,
+//keyed by instance number.
+func recordedInstances(etcd io.EtcdClient, topoName string, nodeName string) (map[int]*instanceRecord, error) {
+	numbers := make(map[int]bool)
+	for _, kind := range []string{INSTANCES, CONTAINERS} {
+		children, found, err := etcd.Children(filepath.Join(io.PICKETT_KEYSPACE, kind, topoName, nodeName))
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		for _, child := range children {
+			x, err := strconv.ParseInt(child, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			numbers[int(x)] = true
+		}
+	}
+	sorted := []int{}
+	for i := range numbers {
+		sorted = append(sorted, i)
+	}
+	sort.Ints(sorted)
+
+	result := make(map[int]*instanceRecord)
+	for _, i := range sorted {
+		rec, found, err := loadRecord(etcd, topoName, nodeName, i)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			result[i] = rec
+		}
+	}
+	return result, nil
+}
+
+//buildRecord is what pickett knows about how it built an image from go source: the image
+//it produced, the settings it used and the digest of each source file (by host path) it
+//was built from.
+type buildRecord struct {
+	ImageID  string
+	Settings string
+	Sources  map[string]string
+}
+
+//buildRecordKey returns the etcd key of the build record of a tag.
+func buildRecordKey(tag string) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, BUILDS, tag)
+}
+
+//loadBuildRecord reads the build record of a tag, returning nil if there is none.
+func loadBuildRecord(tag string, etcd io.EtcdClient) (*buildRecord, error) {
+	value, present, err := etcd.Get(buildRecordKey(tag))
+	if err != nil || !present {
+		return nil, err
+	}
+	rec := &buildRecord{}
+	if err := json.Unmarshal([]byte(value), rec); err != nil {
+		return nil, fmt.Errorf("can't understand build record of %s: %v", tag, err)
+	}
+	return rec, nil
+}
+
+//save writes the build record of a tag, replacing any previous one.
+func (b *buildRecord) save(tag string, etcd io.EtcdClient) error {
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = etcd.Put(buildRecordKey(tag), string(buf))
+	return err
+}
+
+//changed returns a source file that is not the same as when the record was made, or ""
+//if they are all the same.  Files that were added or removed count as changed.
+func (b *buildRecord) changed(sources map[string]string) string {
+	return changedDigest(b.Sources, sources)
+}
+
+//changedDigest returns a name whose digest is not the same in recorded and current, or ""
+//if they are all the same.
+func changedDigest(recorded map[string]string, current map[string]string) string {
+	names := []string{}
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range recorded {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if current[name] != recorded[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+//exportRecord is what pickett knows about the last time it exported the artifacts of an
+//extraction: the image they came from, where they went, the digests of the ones in the
+//source tree and when it was done.
+type exportRecord struct {
+	ImageID     string
+	Destination string
+	Sources     map[string]string
+	Exported    time.Time
+}
+
+//exportRecordKey returns the etcd key of the export record of an extraction.
+func exportRecordKey(tag string) string {
+	return filepath.Join(io.PICKETT_KEYSPACE, EXPORTS, tag)
+}
+
+//loadExportRecord reads the export record of an extraction, returning nil if there is none.
+func loadExportRecord(tag string, etcd io.EtcdClient) (*exportRecord, error) {
+	value, present, err := etcd.Get(exportRecordKey(tag))
+	if err != nil || !present {
+		return nil, err
+	}
+	rec := &exportRecord{}
+	if err := json.Unmarshal([]byte(value), rec); err != nil {
+		return nil, fmt.Errorf("can't understand export record of %s: %v", tag, err)
+	}
+	return rec, nil
+}
+
+//save writes the export record of an extraction, replacing any previous one.
+func (r *exportRecord) save(tag string, etcd io.EtcdClient) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = etcd.Put(exportRecordKey(tag), string(buf))
+	return err
+}
@@ -0,0 +1,86 @@
+package pickett
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//splitTag splits repo:tag, the repository may have a port in it.
+func splitTag(tag string) (string, string) {
+	i := strings.LastIndex(tag, ":")
+	if i < 0 || strings.Contains(tag[i:], "/") {
+		return tag, "latest"
+	}
+	return tag[:i], tag[i+1:]
+}
+
+//retainedTag is the tag of the n-th image a tag pointed to before the current one.
+func retainedTag(tag string, n int) *io.TagInfo {
+	repo, name := splitTag(tag)
+	return &io.TagInfo{Repository: repo, Tag: fmt.Sprintf("%s-%d", name, n)}
+}
+
+//imageID returns the ID of the image tag points to, or "" if there is none.
+func (c *Config) imageID(tag string) string {
+	insp, err := c.cli.InspectImage(tag)
+	if err != nil {
+		return ""
+	}
+	return insp.ID()
+}
+
+//retain keeps previous, the image tag pointed to before it was built, as tag-1.  What
+//was tag-1 becomes tag-2 and so on, up to the number of images to keep.  The image that
+//falls off the end is removed, unless it is still one of the others.
+func (c *Config) retain(tag string, previous string, keep int) {
+	current := c.imageID(tag)
+	if previous == "" || previous == current {
+		return
+	}
+	name := func(n int) string {
+		info := retainedTag(tag, n)
+		return info.Repository + ":" + info.Tag
+	}
+	dropped := c.imageID(name(keep))
+	kept := map[string]bool{current: true, previous: true}
+	for i := keep; i >= 2; i-- {
+		older := c.imageID(name(i - 1))
+		if older == "" {
+			continue
+		}
+		if err := c.cli.CmdTag(older, true, retainedTag(tag, i)); err != nil {
+			flog.Warningf("unable to keep the old image of %s as %s: %v", tag, name(i), err)
+			continue
+		}
+		kept[older] = true
+	}
+	if err := c.cli.CmdTag(previous, true, retainedTag(tag, 1)); err != nil {
+		flog.Warningf("unable to keep the old image of %s as %s: %v", tag, name(1), err)
+		return
+	}
+	flog.Infof("kept the previous image of %s as %s", tag, name(1))
+	if dropped != "" && !kept[dropped] {
+		if err := c.cli.CmdRmImage(dropped); err != nil {
+			flog.Debugf("not removing the oldest image of %s (%s): %v", tag, dropped, err)
+		}
+	}
+}
+
+//removeRetained removes the images of tag kept beyond the newest keep, returning how many
+//tags were removed.  An image is only removed by docker when it has no other tag.
+func (c *Config) removeRetained(tag string, keep int) (int, error) {
+	removed := 0
+	for n := keep + 1; ; n++ {
+		info := retainedTag(tag, n)
+		name := info.Repository + ":" + info.Tag
+		if c.imageID(name) == "" {
+			return removed, nil
+		}
+		if err := c.cli.CmdRmImage(name); err != nil {
+			return removed, fmt.Errorf("unable to remove %s: %v", name, err)
+		}
+		removed++
+	}
+}
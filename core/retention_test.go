@@ -0,0 +1,56 @@
+package pickett
+
+import (
+	"errors"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+//expectImage sets up tag to point at the image id, or at nothing if id is "".
+func expectImage(controller *gomock.Controller, cli *io.MockDockerCli, tag string, id string) {
+	if id == "" {
+		cli.EXPECT().InspectImage(tag).Return(nil, errors.New("no such image"))
+		return
+	}
+	insp := io.NewMockInspectedImage(controller)
+	insp.EXPECT().ID().Return(id)
+	cli.EXPECT().InspectImage(tag).Return(insp, nil)
+}
+
+func TestRetainShiftsOldImages(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	c := &Config{cli: cli}
+
+	//bletch was just rebuilt, two old images are kept and there are two already
+	expectImage(controller, cli, BLETCH, "new")
+	expectImage(controller, cli, BLETCH+"-2", "oldest")
+	expectImage(controller, cli, BLETCH+"-1", "older")
+	cli.EXPECT().CmdTag("older", true, &io.TagInfo{Repository: "blah", Tag: "bletch-2"}).Return(nil)
+	cli.EXPECT().CmdTag("previous", true, &io.TagInfo{Repository: "blah", Tag: "bletch-1"}).Return(nil)
+	cli.EXPECT().CmdRmImage("oldest").Return(nil)
+
+	c.retain(BLETCH, "previous", 2)
+
+	//nothing changes when the build made the same image
+	expectImage(controller, cli, BLETCH, "new")
+	c.retain(BLETCH, "new", 2)
+}
+
+func TestSplitTag(t *testing.T) {
+	for tag, expected := range map[string][2]string{
+		"blah:bletch":            {"blah", "bletch"},
+		"localhost:5000/foo:bar": {"localhost:5000/foo", "bar"},
+		"localhost:5000/foo":     {"localhost:5000/foo", "latest"},
+	} {
+		repo, name := splitTag(tag)
+		if repo != expected[0] || name != expected[1] {
+			t.Errorf("%s split into %s and %s", tag, repo, name)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package pickett
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+//nodeTiming is what one run found out about one node: why it was (or wasn't) built and how
+//long the node itself took to check and to build.  The time spent on its inputs is theirs.
+type nodeTiming struct {
+	Node   string
+	Reason string
+	Check  time.Duration
+	Build  time.Duration
+	Built  bool
+}
+
+//buildTimings collects the timing of each node a run checks or builds, in the order they
+//are first seen.  The zero value is ready to use.
+type buildTimings struct {
+	lock   sync.Mutex
+	order  []string
+	byNode map[string]*nodeTiming
+}
+
+//timing returns the timing of the node name, creating it if needed.  The caller holds the lock.
+func (b *buildTimings) timing(name string) *nodeTiming {
+	if b.byNode == nil {
+		b.byNode = make(map[string]*nodeTiming)
+	}
+	t, ok := b.byNode[name]
+	if !ok {
+		t = &nodeTiming{Node: name}
+		b.byNode[name] = t
+		b.order = append(b.order, name)
+	}
+	return t
+}
+
+//checked records a check of whether name is out of date.
+func (b *buildTimings) checked(name string, reason string, took time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.timing(name)
+	t.Check += took
+	if !t.Built {
+		t.Reason = reason
+	}
+}
+
+//built records a build of name.
+func (b *buildTimings) built(name string, took time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.timing(name)
+	t.Build += took
+	t.Built = true
+	if t.Reason == "" {
+		t.Reason = "out of date"
+	}
+}
+
+//all returns a copy of the timings, in order.
+func (b *buildTimings) all() []nodeTiming {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := []nodeTiming{}
+	for _, name := range b.order {
+		result = append(result, *b.byNode[name])
+	}
+	return result
+}
+
+//PrintTimings prints a summary of the nodes this run checked or built: the reason, the
+//time taken and whether the image we had could be used (a hit) or had to be built (a
+//miss).  Nothing is printed if no node was looked at.
+func (c *Config) PrintTimings() {
+	timings := c.timings.all()
+	if len(timings) == 0 {
+		return
+	}
+	fmt.Println("[pickett] build summary")
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tCACHE\tCHECK\tBUILD\tREASON")
+	var total time.Duration
+	for _, t := range timings {
+		cache, build := "hit", "-"
+		if t.Built {
+			cache, build = "miss", roundDuration(t.Build).String()
+		}
+		total += t.Check + t.Build
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Node, cache, roundDuration(t.Check), build, t.Reason)
+	}
+	fmt.Fprintf(w, "total\t\t\t%s\t\n", roundDuration(total))
+	w.Flush()
+}
+
+//roundDuration keeps durations readable in the summary.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d - d%time.Millisecond
+	}
+	return d - d%(10*time.Millisecond)
+}
@@ -1,6 +1,8 @@
 package pickett
 
 import (
+	"fmt"
+
 	"github.com/igneous-systems/pickett/io"
 )
 
@@ -25,6 +27,10 @@ type topoRunner struct {
 	devs          map[string]string
 	priv          bool
 	wait          bool
+	stop          io.StopConfig
+	preStop       []string
+	hash          string
+	onHost        string
 }
 
 func (n *topoRunner) name() string {
@@ -55,6 +61,30 @@ func (n *topoRunner) contName() string {
 	return n.containerName
 }
 
+func (n *topoRunner) stopConfig() io.StopConfig {
+	return n.stop
+}
+
+func (n *topoRunner) preStopCommand() []string {
+	return n.preStop
+}
+
+func (n *topoRunner) policyName() string {
+	return n.policy.String()
+}
+
+func (n *topoRunner) configHash() string {
+	return n.hash
+}
+
+func (n *topoRunner) host() string {
+	return n.onHost
+}
+
+func (n *topoRunner) consumed() []runner {
+	return n.consumes
+}
+
 //in returns a single node that is our inbound edge, the container we run in.
 func (n *topoRunner) in() []node {
 	result := []node{}
@@ -82,7 +112,19 @@ func (n *topoRunner) run(teeOutput bool, conf *Config, topoName string, instance
 		}
 		links[input.containerName] = input.r.name()
 	}
+	return n.launch(teeOutput, conf, topoName, instance, rv, links)
+}
 
+// launch applies the policy to this network only.  The networks that this one consumes
+// must already have been handled by the caller, who supplies the links to them.  The
+// instance is locked while its policy is applied.
+func (n *topoRunner) launch(teeOutput bool, conf *Config, topoName string, instance int, rv *runVolumeSpec, links map[string]string) (*policyInput, error) {
+	//another pickett applying the policy at the same time would start a second container
+	lock, _, err := conf.lock("nodes", topoName, n.name(), fmt.Sprint(instance))
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
 	in, err := createPolicyInput(n, topoName, instance, conf)
 	if err != nil {
 		return nil, err
@@ -91,8 +133,11 @@ func (n *topoRunner) run(teeOutput bool, conf *Config, topoName string, instance
 	return in, n.policy.appyPolicy(teeOutput, in, topoName, instance, links, rv, conf)
 }
 
-// imageIsOutOfDate delegates to the image if it is a node, otherwise false.
+// imageIsOutOfDate delegates to the image if it is a node, otherwise false.  The dependency
+// graph is not safe for concurrent use, so this holds the config's build lock.
 func (n *topoRunner) imageIsOutOfDate(conf *Config) (bool, error) {
+	conf.buildLock.Lock()
+	defer conf.buildLock.Unlock()
 	if !n.runIn.isNode {
 		flog.Debugf("'%s' can't be out of date, image '%s' is not buildable", n.name(), n.runIn.name)
 		return false, nil
@@ -102,6 +147,8 @@ func (n *topoRunner) imageIsOutOfDate(conf *Config) (bool, error) {
 
 // we build the image if indeed that is possible
 func (n *topoRunner) imageBuild(conf *Config) error {
+	conf.buildLock.Lock()
+	defer conf.buildLock.Unlock()
 	if !n.runIn.isNode {
 		flog.Warningf("'%s' can't be built, image '%s' is not buildable", n.name(), n.runIn.name)
 		return nil
@@ -1,6 +1,9 @@
 package pickett
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -70,9 +73,9 @@ func TestMultipleInstances(T *testing.T) {
 	oneMinAgo := now.Add(-1 * time.Minute)
 	oneHrAgoOneMin := oneHrAgo.Add(-1 * time.Minute)
 
-	PART3KEY0 := "/pickett" + "/" + CONTAINERS + "/" + "someothergraph" + "/" + "part3" + "/" + "0"
-	PART3KEY1 := "/pickett" + "/" + CONTAINERS + "/" + "someothergraph" + "/" + "part3" + "/" + "1"
-	PART4 := "/pickett" + "/" + CONTAINERS + "/" + "someothergraph" + "/" + "part4" + "/" + "0"
+	PART3KEY0 := "/pickett" + "/" + INSTANCES + "/" + "someothergraph" + "/" + "part3" + "/" + "0"
+	PART3KEY1 := "/pickett" + "/" + INSTANCES + "/" + "someothergraph" + "/" + "part3" + "/" + "1"
+	PART4 := "/pickett" + "/" + INSTANCES + "/" + "someothergraph" + "/" + "part4" + "/" + "0"
 
 	//called as part of config check
 	helper.EXPECT().OpenDockerfileRelative("somedir").Return(nil, nil)
@@ -83,9 +86,17 @@ func TestMultipleInstances(T *testing.T) {
 	cli.EXPECT().InspectImage("part4-image").Return(ignoredInspect, nil)
 
 	//it's going to try to get the instances that already exist for part3, we return as if
-	//there were none
+	//there were none, in either layout
 	etcd.EXPECT().Get(PART3KEY0).Return("", false, nil)
 	etcd.EXPECT().Get(PART3KEY1).Return("", false, nil)
+	expectNotInOldLayout(etcd, "someothergraph", "part3", 0)
+	expectNotInOldLayout(etcd, "someothergraph", "part3", 1)
+
+	//each instance is locked while its policy is applied, part4 once for each part3
+	expectLock(etcd, "/pickett/locks/nodes/someothergraph/part3/0")
+	expectLock(etcd, "/pickett/locks/nodes/someothergraph/part3/1")
+	expectLock(etcd, "/pickett/locks/nodes/someothergraph/part4/0")
+	expectLock(etcd, "/pickett/locks/nodes/someothergraph/part4/0")
 
 	//pass
 	cli.EXPECT().CmdRun(gomock.Any(), "/bin/part3-start.sh", "someothergraph", "0").Return(nil, "p3cont0", nil)
@@ -95,7 +106,7 @@ func TestMultipleInstances(T *testing.T) {
 	//twice, one for each instance of part3
 	HENDRIX := "merdered_hendrix"
 	hendrixCont := io.NewMockInspectedContainer(controller)
-	etcd.EXPECT().Get(PART4).Return(HENDRIX, true, nil).Times(2)
+	etcd.EXPECT().Get(PART4).Return(`{"ContainerName":"`+HENDRIX+`"}`, true, nil).Times(2)
 	cli.EXPECT().InspectContainer(HENDRIX).Return(hendrixCont, nil).Times(2)
 	hendrixCont.EXPECT().Running().Return(true).Times(2)
 	hendrixCont.EXPECT().CreatedTime().Return(oneMinAgo).Times(2)
@@ -107,23 +118,23 @@ func TestMultipleInstances(T *testing.T) {
 	PORT0 := "1023"
 	PORT1 := "1022"
 	vanZant0 := io.NewMockInspectedContainer(controller)
-	vanZant0.EXPECT().ContainerName().Return("rvanzant0").Times(2)
+	vanZant0.EXPECT().ContainerID().Return("p3cont0")
+	vanZant0.EXPECT().ContainerName().Return("rvanzant0")
+	vanZant0.EXPECT().ImageID().Return("part3-image-id")
 	vanZant0.EXPECT().Ip().Return(IP0)
-	vanZant0.EXPECT().Ports().Return([]string{PORT0})
+	vanZant0.EXPECT().PortMap().Return(map[string][]string{PORT0: {"0.0.0.0:" + PORT0}})
 	vanZant1 := io.NewMockInspectedContainer(controller)
-	vanZant1.EXPECT().ContainerName().Return("rvanzant1").Times(2)
+	vanZant1.EXPECT().ContainerID().Return("p3cont1")
+	vanZant1.EXPECT().ContainerName().Return("rvanzant1")
+	vanZant1.EXPECT().ImageID().Return("part3-image-id")
 	vanZant1.EXPECT().Ip().Return(IP1)
-	vanZant1.EXPECT().Ports().Return([]string{PORT1})
+	vanZant1.EXPECT().PortMap().Return(map[string][]string{PORT1: {"0.0.0.0:" + PORT1}})
 
 	cli.EXPECT().InspectContainer("p3cont0").Return(vanZant0, nil)
 	cli.EXPECT().InspectContainer("p3cont1").Return(vanZant1, nil)
 
-	etcd.EXPECT().Put("/pickett/containers/someothergraph/part3/0", "rvanzant0").Return("ignored0", nil)
-	etcd.EXPECT().Put("/pickett/containers/someothergraph/part3/1", "rvanzant1").Return("ignored1", nil)
-	etcd.EXPECT().Put("/pickett/ips/someothergraph/part3/0", IP0).Return("ignored-ip0", nil)
-	etcd.EXPECT().Put("/pickett/ips/someothergraph/part3/1", IP1).Return("ignored-ip1", nil)
-	etcd.EXPECT().Put("/pickett/ports/someothergraph/part3/0", PORT0).Return("ignored-port0", nil)
-	etcd.EXPECT().Put("/pickett/ports/someothergraph/part3/1", PORT1).Return("ignored-port1", nil)
+	etcd.EXPECT().Put(PART3KEY0, hasRecord{"rvanzant0", IP0}).Return("ignored0", nil)
+	etcd.EXPECT().Put(PART3KEY1, hasRecord{"rvanzant1", IP1}).Return("ignored1", nil)
 
 	c, err := NewConfig(strings.NewReader(netExample), helper, cli, etcd)
 	if err != nil {
@@ -140,3 +151,432 @@ func TestMultipleInstances(T *testing.T) {
 	}
 
 }
+
+var diamondExample = `
+// d consumes b and c, which both consume a.
+{
+	"Topologies" : {
+		"diamond" : [
+			{
+				"Name": "d",
+				"RunIn": "some-image",
+				"Consumes": ["b", "c"]
+			},
+			{
+				"Name": "c",
+				"RunIn": "some-image",
+				"Consumes": ["a"]
+			},
+			{
+				"Name": "b",
+				"RunIn": "some-image",
+				"Consumes": ["a"]
+			},
+			{
+				"Name": "a",
+				"RunIn": "some-image"
+			}
+		]
+	}
+}
+`
+
+var cycleExample = `
+{
+	"Topologies" : {
+		"loop" : [
+			{
+				"Name": "x",
+				"RunIn": "some-image",
+				"Consumes": ["y"]
+			},
+			{
+				"Name": "y",
+				"RunIn": "some-image",
+				"Consumes": ["x"]
+			}
+		]
+	}
+}
+`
+
+func TestTopologyOrder(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	cli.EXPECT().InspectImage("some-image").Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+
+	c, err := NewConfig(strings.NewReader(diamondExample), helper, cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse legal config file: %v", err)
+	}
+	levels, err := c.topologyOrder("diamond")
+	if err != nil {
+		T.Fatalf("unexpected error ordering topology: %v", err)
+	}
+	expected := [][]string{{"a"}, {"b", "c"}, {"d"}}
+	if len(levels) != len(expected) {
+		T.Fatalf("wrong number of levels, expected %v but got %v", expected, levels)
+	}
+	for i := range expected {
+		if strings.Join(levels[i], ",") != strings.Join(expected[i], ",") {
+			T.Errorf("wrong level %d, expected %v but got %v", i, expected[i], levels[i])
+		}
+	}
+
+	stop := reversed(chosenRunnables(c, []string{"diamond"}))
+	if stop[0] != "diamond.d" || stop[len(stop)-1] != "diamond.a" {
+		T.Errorf("wrong stop order: %v", stop)
+	}
+
+	if _, err := NewConfig(strings.NewReader(cycleExample), helper, cli, etcd); err == nil {
+		T.Errorf("expected an error from a topology with a cycle")
+	}
+}
+
+var gracefulExample = `
+{
+	"Topologies" : {
+		"db" : [
+			{
+				"Name": "postgres",
+				"RunIn": "some-image",
+				"StopTimeout": 30,
+				"StopSignal": "SIGINT",
+				"PreStop": ["/bin/checkpoint", "now"]
+			}
+		]
+	}
+}
+`
+
+func TestStopUsesTopologySettings(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	cli.EXPECT().InspectImage("some-image").Return(io.NewMockInspectedImage(controller), nil)
+
+	c, err := NewConfig(strings.NewReader(gracefulExample), helper, cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse legal config file: %v", err)
+	}
+	r := c.nameToTopology["db"]["postgres"].runner
+
+	//pre-stop failing should not prevent the stop
+	first := cli.EXPECT().CmdExec("pg0", "/bin/checkpoint", "now").Return(nil, errors.New("no checkpoint"))
+	cli.EXPECT().CmdStop("pg0", &io.StopConfig{Timeout: 30, Signal: "SIGINT"}).After(first)
+	if err := stopContainer(r, "pg0", -1, cli); err != nil {
+		T.Fatalf("unexpected error stopping: %v", err)
+	}
+
+	//the command line timeout wins
+	cli.EXPECT().CmdExec("pg0", "/bin/checkpoint", "now").Return(nil, nil)
+	cli.EXPECT().CmdStop("pg0", &io.StopConfig{Timeout: 5, Signal: "SIGINT"})
+	if err := stopContainer(r, "pg0", 5, cli); err != nil {
+		T.Fatalf("unexpected error stopping: %v", err)
+	}
+}
+
+var continueExample = `
+{
+	"Topologies" : {
+		"dev" : [
+			{
+				"Name": "db",
+				"RunIn": "registry:5000/mydb:v1",
+				"EntryPoint": ["/start.sh"],
+				"Policy": "CONTINUE"
+			}
+		]
+	}
+}
+`
+
+//runsImage matches a *io.RunConfig that runs a particular image.
+type runsImage string
+
+func (m runsImage) Matches(x interface{}) bool {
+	rc, ok := x.(*io.RunConfig)
+	return ok && rc.Image == string(m)
+}
+
+func (m runsImage) String() string {
+	return "runs image " + string(m)
+}
+
+//expectNotInOldLayout expects an instance to be looked for in the layout from before
+//records, and not found.
+func expectNotInOldLayout(etcd *io.MockEtcdClient, topoName string, nodeName string, instance int) {
+	for _, kind := range []string{CONTAINERS, IPS, PORTS} {
+		etcd.EXPECT().Get(oldKey(kind, topoName, nodeName, instance)).Return("", false, nil)
+	}
+}
+
+//hasRecord matches the json of an instance record with the given container name and ip.
+type hasRecord struct {
+	name string
+	ip   string
+}
+
+func (m hasRecord) Matches(x interface{}) bool {
+	s, ok := x.(string)
+	if !ok {
+		return false
+	}
+	rec := &instanceRecord{}
+	if err := json.Unmarshal([]byte(s), rec); err != nil {
+		return false
+	}
+	return rec.ContainerName == m.name && rec.IP == m.ip
+}
+
+func (m hasRecord) String() string {
+	return fmt.Sprintf("has record of %s at %s", m.name, m.ip)
+}
+
+func TestContinueCommitsAndResumes(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+
+	cli.EXPECT().InspectImage("registry:5000/mydb:v1").Return(io.NewMockInspectedImage(controller), nil)
+	c, err := NewConfig(strings.NewReader(continueExample), helper, cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	RECORDKEY := "/pickett/instances/dev/db/0"
+	LINEAGEKEY := "/pickett/continues/dev/db/0"
+	expectLock(etcd, "/pickett/locks/nodes/dev/db/0")
+
+	//the container exists but has stopped, and was recorded in the old layout so it is
+	//migrated to a record first
+	etcd.EXPECT().Get(RECORDKEY).Return("", false, nil)
+	etcd.EXPECT().Get("/pickett/containers/dev/db/0").Return("stopped_db", true, nil)
+	etcd.EXPECT().Get("/pickett/ips/dev/db/0").Return("1.2.3.3", true, nil)
+	etcd.EXPECT().Get("/pickett/ports/dev/db/0").Return("5432", true, nil)
+	etcd.EXPECT().Put(RECORDKEY, hasRecord{"stopped_db", "1.2.3.3"}).Return("", nil)
+	etcd.EXPECT().Del("/pickett/containers/dev/db/0").Return("stopped_db", nil)
+	etcd.EXPECT().Del("/pickett/ips/dev/db/0").Return("1.2.3.3", nil)
+	etcd.EXPECT().Del("/pickett/ports/dev/db/0").Return("5432", nil)
+	stopped := io.NewMockInspectedContainer(controller)
+	stopped.EXPECT().Running().Return(false)
+	stopped.EXPECT().CreatedTime().Return(time.Now())
+	stopped.EXPECT().ContainerName().Return("stopped_db")
+	cli.EXPECT().InspectContainer("stopped_db").Return(stopped, nil)
+
+	//commit it to the continue tag and record it
+	etcd.EXPECT().Get(LINEAGEKEY).Return("", false, nil)
+	cli.EXPECT().CmdCommit("stopped_db", &io.TagInfo{Repository: "registry:5000/mydb-continue", Tag: "dev-db-0"}).
+		Return("committed1", nil)
+	etcd.EXPECT().Put(LINEAGEKEY, gomock.Any()).Return("", nil)
+	cli.EXPECT().CmdRmContainer("stopped_db").Return(nil)
+
+	//start from the committed image
+	cli.EXPECT().CmdRun(runsImage("committed1"), "/start.sh", "dev", "0").Return(nil, "newcont", nil)
+	started := io.NewMockInspectedContainer(controller)
+	started.EXPECT().ContainerID().Return("newcont")
+	started.EXPECT().ContainerName().Return("resumed_db")
+	started.EXPECT().ImageID().Return("committed1")
+	started.EXPECT().Ip().Return("1.2.3.4")
+	started.EXPECT().PortMap().Return(map[string][]string{})
+	cli.EXPECT().InspectContainer("newcont").Return(started, nil)
+	etcd.EXPECT().Put(RECORDKEY, hasRecord{"resumed_db", "1.2.3.4"}).Return("", nil)
+
+	if _, err := c.Execute("dev.db", nil); err != nil {
+		T.Fatalf("error continuing: %v", err)
+	}
+}
+
+func TestResetRemovesStoppedContainer(T *testing.T) {
+	controller := gomock.NewController(T)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	etcd := io.NewMockEtcdClient(controller)
+	cli.EXPECT().InspectImage("registry:5000/mydb:v1").Return(io.NewMockInspectedImage(controller), nil)
+	c, err := NewConfig(strings.NewReader(continueExample), io.NewMockHelper(controller), cli, etcd)
+	if err != nil {
+		T.Fatalf("can't parse legal config file: %v", err)
+	}
+	r := c.nameToTopology["dev"]["db"].runner
+
+	//the policy stopped the container, which dropped its record, then the image was rebuilt
+	etcd.EXPECT().Get("/pickett/instances/dev/db/0").Return("", false, nil)
+	expectNotInOldLayout(etcd, "dev", "db", 0)
+	stopped := io.NewMockInspectedContainer(controller)
+	stopped.EXPECT().Running().Return(false)
+	cli.EXPECT().InspectContainer("stopped_db").Return(stopped, nil)
+	cli.EXPECT().CmdRmContainer("stopped_db").Return(nil)
+	etcd.EXPECT().Get("/pickett/continues/dev/db/0").Return("", false, nil)
+
+	if err := resetContinuation(r, "dev", 0, "stopped_db", c); err != nil {
+		T.Fatalf("unexpected error resetting: %v", err)
+	}
+}
+
+var waitForExample = `
+{
+	"Topologies" : {
+		"dev" : [
+			{
+				"Name": "migrate",
+				"RunIn": "migrate-image",
+				"WaitFor": true
+			},
+			{
+				"Name": "app",
+				"RunIn": "app-image",
+				"Consumes": ["migrate"]
+			}
+		]
+	}
+}
+`
+
+//attached matches a *io.RunConfig that is waited for, with its output shown.
+type attached bool
+
+func (m attached) Matches(x interface{}) bool {
+	rc, ok := x.(*io.RunConfig)
+	return ok && rc.Attach == bool(m) && rc.WaitOutput == bool(m)
+}
+
+func (m attached) String() string {
+	return fmt.Sprintf("run config with output attached: %v", bool(m))
+}
+
+//expectStarted expects node of the dev topology of waitForExample to be run, with status
+//as the exit status of its container if it is waited for.
+func expectStarted(controller *gomock.Controller, cli *io.MockDockerCli, etcd *io.MockEtcdClient, node string, waited bool, status int) {
+	etcd.EXPECT().Get(recordKey("dev", node, 0)).Return("", false, nil)
+	expectNotInOldLayout(etcd, "dev", node, 0)
+	expectLock(etcd, lockKey("nodes", "dev", node, "0"))
+	cli.EXPECT().CmdRun(attached(waited), "dev", "0").Return(nil, node+"-id", nil)
+	cont := io.NewMockInspectedContainer(controller)
+	cont.EXPECT().ContainerID().Return(node + "-id")
+	cont.EXPECT().ContainerName().Return(node + "0")
+	cont.EXPECT().ImageID().Return(node + "-image-id")
+	cont.EXPECT().Ip().Return("1.2.3.4")
+	cont.EXPECT().PortMap().Return(map[string][]string{})
+	cli.EXPECT().InspectContainer(node+"-id").Return(cont, nil)
+	etcd.EXPECT().Put(recordKey("dev", node, 0), hasRecord{node + "0", "1.2.3.4"}).Return("", nil)
+	if waited {
+		cont.EXPECT().ExitStatus().Return(status)
+		cli.EXPECT().InspectContainer(node+"0").Return(cont, nil)
+	}
+}
+
+func TestTopologyWaitsForWaitFor(t *testing.T) {
+	for _, status := range []int{0, 3} {
+		controller := gomock.NewController(t)
+
+		cli := io.NewMockDockerCli(controller)
+		etcd := io.NewMockEtcdClient(controller)
+		cli.EXPECT().InspectImage(gomock.Any()).Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+		c, err := NewConfig(strings.NewReader(waitForExample), io.NewMockHelper(controller), cli, etcd)
+		if err != nil {
+			t.Fatalf("can't parse legal config file: %v", err)
+		}
+
+		//the app only starts once the migration has finished, and not if it failed
+		expectStarted(controller, cli, etcd, "migrate", true, status)
+		if status == 0 {
+			expectStarted(controller, cli, etcd, "app", false, 0)
+		}
+		result, err := c.Execute("dev", nil)
+		if err != nil {
+			t.Errorf("unexpected error running the topology: %v", err)
+		} else if result != status {
+			t.Errorf("expected exit status %d, but got %d", status, result)
+		}
+		controller.Finish()
+	}
+}
+
+func TestNoExitStatusOfWhatWasNotStarted(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	cli.EXPECT().InspectImage(gomock.Any()).Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+	c, err := NewConfig(strings.NewReader(waitForExample), io.NewMockHelper(controller), cli, io.NewMockEtcdClient(controller))
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+
+	//the policy left the node alone, so there is no container to inspect
+	r := c.nameToTopology["dev"]["migrate"].runner
+	if status, err := c.exitStatus(r, &policyInput{r: r}); err != nil || status != 0 {
+		t.Errorf("expected no exit status for a node that was not started, got %d (%v)", status, err)
+	}
+}
+
+func TestStopTimeoutZeroIsNotTheDefault(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	cli := io.NewMockDockerCli(controller)
+	cli.EXPECT().InspectImage(gomock.Any()).Return(io.NewMockInspectedImage(controller), nil).AnyTimes()
+	config := strings.Replace(waitForExample, `"WaitFor": true`, `"WaitFor": true, "StopTimeout": 0`, 1)
+	c, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), cli, io.NewMockEtcdClient(controller))
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	if timeout := c.nameToTopology["dev"]["migrate"].runner.stopConfig().Timeout; timeout != 0 {
+		t.Errorf("expected a StopTimeout of 0 to be kept, got %d", timeout)
+	}
+	if timeout := c.nameToTopology["dev"]["app"].runner.stopConfig().Timeout; timeout != io.DEFAULT_STOP_TIMEOUT {
+		t.Errorf("expected the default stop timeout, got %d", timeout)
+	}
+
+	config = strings.Replace(waitForExample, `"WaitFor": true`, `"WaitFor": true, "StopTimeout": -1`, 1)
+	if _, err := NewConfig(strings.NewReader(config), io.NewMockHelper(controller), cli, io.NewMockEtcdClient(controller)); err == nil {
+		t.Errorf("expected an error for a negative StopTimeout")
+	}
+}
+
+func TestMigrateRecordCleansTheOldLayout(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	etcd := io.NewMockEtcdClient(controller)
+
+	//only the ip of instance 0 is left, it goes
+	etcd.EXPECT().Get(oldKey(CONTAINERS, "dev", "db", 0)).Return("", false, nil)
+	etcd.EXPECT().Get(oldKey(IPS, "dev", "db", 0)).Return("1.2.3.3", true, nil)
+	etcd.EXPECT().Get(oldKey(PORTS, "dev", "db", 0)).Return("", false, nil)
+	etcd.EXPECT().Del(oldKey(IPS, "dev", "db", 0)).Return("1.2.3.3", nil)
+	if _, present, err := migrateRecord(etcd, "dev", "db", 0); err != nil || present {
+		t.Errorf("expected no record from a stale ip, got %v (%v)", present, err)
+	}
+
+	//the ports of instance 1 are named like docker names them
+	etcd.EXPECT().Get(oldKey(CONTAINERS, "dev", "db", 1)).Return("db1", true, nil)
+	etcd.EXPECT().Get(oldKey(IPS, "dev", "db", 1)).Return("", false, nil)
+	etcd.EXPECT().Get(oldKey(PORTS, "dev", "db", 1)).Return("5432 53/udp", true, nil)
+	etcd.EXPECT().Put(recordKey("dev", "db", 1), gomock.Any()).Return("", nil)
+	etcd.EXPECT().Del(oldKey(CONTAINERS, "dev", "db", 1)).Return("db1", nil)
+	etcd.EXPECT().Del(oldKey(PORTS, "dev", "db", 1)).Return("5432 53/udp", nil)
+	rec, present, err := migrateRecord(etcd, "dev", "db", 1)
+	if err != nil || !present {
+		t.Fatalf("expected a record, got %v (%v)", present, err)
+	}
+	if _, ok := rec.Ports["5432/tcp"]; !ok || len(rec.Ports) != 2 {
+		t.Errorf("wrong ports migrated: %v", rec.Ports)
+	}
+	if _, ok := rec.Ports["53/udp"]; !ok {
+		t.Errorf("wrong ports migrated: %v", rec.Ports)
+	}
+}
@@ -0,0 +1,163 @@
+package pickett
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//topologyOrder returns the nodes of a topology grouped into levels.  Every node in a level
+//consumes only nodes from earlier levels, so the nodes within one level can be started
+//in parallel.  It is an error for nodes to consume each other in a cycle.
+func (c *Config) topologyOrder(topoName string) ([][]string, error) {
+	tmap, ok := c.nameToTopology[topoName]
+	if !ok {
+		return nil, fmt.Errorf("no such topology: '%s'", topoName)
+	}
+	byRunner := make(map[runner]string)
+	for name, info := range tmap {
+		byRunner[info.runner] = name
+	}
+
+	//number of consumed nodes not yet placed in a level
+	waiting := make(map[string]int)
+	for name, info := range tmap {
+		waiting[name] = len(info.runner.consumed())
+	}
+
+	result := [][]string{}
+	placed := 0
+	for placed < len(tmap) {
+		level := []string{}
+		for name, count := range waiting {
+			if count == 0 {
+				level = append(level, name)
+			}
+		}
+		if len(level) == 0 {
+			stuck := []string{}
+			for name := range waiting {
+				stuck = append(stuck, name)
+			}
+			sort.Strings(stuck)
+			return nil, fmt.Errorf("nodes in topology %s consume each other in a cycle: %v", topoName, stuck)
+		}
+		sort.Strings(level)
+		for _, name := range level {
+			delete(waiting, name)
+		}
+		for name := range waiting {
+			for _, r := range tmap[name].runner.consumed() {
+				if contains(level, byRunner[r]) {
+					waiting[name]--
+				}
+			}
+		}
+		result = append(result, level)
+		placed += len(level)
+	}
+	return result, nil
+}
+
+//topologyTargets returns the topo.node names of a topology in the order they should be
+//started.  Reverse it to get the order they should be torn down.
+func (c *Config) topologyTargets(topoName string) []string {
+	//topologies with cycles are rejected when the config is parsed
+	levels, _ := c.topologyOrder(topoName)
+	result := []string{}
+	for _, level := range levels {
+		for _, name := range level {
+			result = append(result, topoName+"."+name)
+		}
+	}
+	return result
+}
+
+//executeTopology brings up every node in a topology.  Nodes are started a level at a time,
+//with the nodes in each level started in parallel.  Consumed nodes are never started twice,
+//the links to them come from the level that started them.  Nodes that are WaitFor are run
+//to completion before the next level starts, which is not started if one of them fails.
+func (c *Config) executeTopology(topoName string, vol *runVolumeSpec) (int, error) {
+	levels, err := c.topologyOrder(topoName)
+	if err != nil {
+		return 1, err
+	}
+	tmap := c.nameToTopology[topoName]
+
+	//results from earlier levels, keyed by runner, only instance 0 can be consumed
+	started := make(map[runner]*policyInput)
+	for _, level := range levels {
+		//links are worked out before any goroutine can touch started
+		links := make(map[string]map[string]string)
+		for _, name := range level {
+			links[name] = make(map[string]string)
+			for _, r := range tmap[name].runner.consumed() {
+				in, ok := started[r]
+				if !ok || in == nil || in.containerName == "" {
+					flog.Debugf("%s consumes %s, but it was not started", name, r.name())
+					continue
+				}
+				links[name][in.containerName] = r.name()
+			}
+		}
+
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		var firstErr error
+		exitStatus := 0
+		for _, name := range level {
+			info := tmap[name]
+			wg.Add(1)
+			go func(name string, info *topoInfo) {
+				defer wg.Done()
+				for i := 0; i < info.instances; i++ {
+					//like Execute, only the last instance is waited for
+					wait := info.runner.waitFor() && i == info.instances-1
+					flog.Debugf("launching %s.%s (instance %d)", topoName, name, i)
+					p, err := info.runner.launch(wait, c, topoName, i, vol, links[name])
+					status := 0
+					if err == nil && wait {
+						status, err = c.exitStatus(info.runner, p)
+					}
+					lock.Lock()
+					if status != 0 && exitStatus == 0 {
+						exitStatus = status
+					}
+					if err != nil && firstErr == nil {
+						firstErr = fmt.Errorf("%s.%s: %v", topoName, name, err)
+					}
+					if err == nil && i == 0 {
+						started[info.runner] = p
+					}
+					lock.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}(name, info)
+		}
+		wg.Wait()
+		if firstErr != nil {
+			return 1, firstErr
+		}
+		//what consumes a node we waited for expects it to have finished its work
+		if exitStatus != 0 {
+			return exitStatus, nil
+		}
+	}
+	return 0, nil
+}
+
+//exitStatus returns the exit status of the container p ran r in, which has finished.  It
+//is 0 if the policy did not start a container.
+func (c *Config) exitStatus(r runner, p *policyInput) (int, error) {
+	if p == nil || p.containerName == "" {
+		flog.Debugf("not waiting for %s, it was not started", r.name())
+		return 0, nil
+	}
+	insp, err := c.cliOf(r).InspectContainer(p.containerName)
+	if err != nil {
+		return 1, err
+	}
+	return insp.ExitStatus(), nil
+}
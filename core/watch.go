@@ -0,0 +1,213 @@
+package pickett
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//watchRunners returns the runners that running target brings up: every node of a topology,
+//or a topology node and everything it consumes.
+func (c *Config) watchRunners(target string) ([]runner, error) {
+	pair := strings.Split(strings.Trim(target, " \n"), ".")
+	tmap, ok := c.nameToTopology[pair[0]]
+	if !ok {
+		return nil, fmt.Errorf("no such topology: '%s'", pair[0])
+	}
+	if len(pair) == 1 {
+		result := []runner{}
+		for _, info := range tmap {
+			result = append(result, info.runner)
+		}
+		return result, nil
+	}
+	if len(pair) != 2 {
+		return nil, fmt.Errorf("unable to understand '%s', expect something like 'foo.bar'", target)
+	}
+	info, ok := tmap[pair[1]]
+	if !ok {
+		return nil, fmt.Errorf("no such node %s in topology %s", pair[1], pair[0])
+	}
+	seen := make(map[runner]bool)
+	var walk func(r runner)
+	walk = func(r runner) {
+		if seen[r] {
+			return
+		}
+		seen[r] = true
+		for _, other := range r.consumed() {
+			walk(other)
+		}
+	}
+	walk(info.runner)
+	result := []runner{}
+	for r := range seen {
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+//watchNodes returns the buildable nodes that the images of the runners are built from,
+//including the nodes those are built from.
+func watchNodes(runners []runner) []node {
+	seen := make(map[node]bool)
+	result := []node{}
+	var walk func(n node)
+	walk = func(n node) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		result = append(result, n)
+		for _, in := range n.implementation().in() {
+			walk(in)
+		}
+	}
+	for _, r := range runners {
+		topo, ok := r.(*topoRunner)
+		if !ok {
+			continue
+		}
+		for _, n := range topo.in() {
+			walk(n)
+		}
+	}
+	return result
+}
+
+//watchDirs returns the directories (full paths) that the nodes are built from.  Go builds
+//are built from the code volumes and their module, if they have one.
+func (c *Config) watchDirs(nodes []node) []string {
+	dirs := make(map[string]bool)
+	for _, n := range nodes {
+		switch b := n.implementation().(type) {
+		case *containerBuilder:
+			dirs[c.helper.DirectoryRelative(b.dir)] = true
+		case *goBuilder:
+			for _, v := range c.CodeVolumes {
+				dirs[c.helper.DirectoryRelative(v.Directory)] = true
+			}
+			if b.testFile != "" {
+				dirs[filepath.Dir(c.helper.DirectoryRelative(b.testFile))] = true
+			}
+			if b.module != "" {
+				dirs[c.helper.DirectoryRelative(b.module)] = true
+			}
+		}
+	}
+	result := []string{}
+	for d := range dirs {
+		result = append(result, d)
+	}
+	sort.Strings(result)
+	return result
+}
+
+//within is true if path is dir or something inside it.
+func within(path string, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimRight(dir, "/")+"/")
+}
+
+//sourceOf is true if path is part of what n is built from.  For go builds that means
+//inside the module or one of its packages in a code volume.
+func (c *Config) sourceOf(n node, path string) bool {
+	switch b := n.implementation().(type) {
+	case *containerBuilder:
+		return within(path, c.helper.DirectoryRelative(b.dir))
+	case *goBuilder:
+		if b.testFile != "" && path == c.helper.DirectoryRelative(b.testFile) {
+			return true
+		}
+		if b.module != "" && within(path, c.helper.DirectoryRelative(b.module)) {
+			return true
+		}
+		for _, v := range c.CodeVolumes {
+			dir := c.helper.DirectoryRelative(v.Directory)
+			if !within(path, dir) {
+				continue
+			}
+			rel := filepath.ToSlash(path[len(dir):]) + "/"
+			for _, pkg := range b.pkgs {
+				pkg = strings.Trim(strings.TrimSuffix(pkg, "..."), "/")
+				if pkg != "" && strings.Contains(rel, "/"+pkg+"/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+//affectedNodes returns the nodes that the changed paths are part of.  A change in a code
+//volume that is in none of the go builds' packages (a library they share, say) could
+//affect any of them, so all the go builds are returned and their build records decide.
+func (c *Config) affectedNodes(nodes []node, changed []string) []node {
+	affected := make(map[node]bool)
+	anyGo := false
+	for _, path := range changed {
+		claimed := false
+		for _, n := range nodes {
+			if c.sourceOf(n, path) {
+				affected[n] = true
+				claimed = true
+			}
+		}
+		if claimed {
+			continue
+		}
+		for _, v := range c.CodeVolumes {
+			if within(path, c.helper.DirectoryRelative(v.Directory)) {
+				anyGo = true
+			}
+		}
+	}
+	result := []node{}
+	for _, n := range nodes {
+		_, isGo := n.implementation().(*goBuilder)
+		if affected[n] || (anyGo && isGo) {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+//debounce waits for a change and then collects more until there has been none for quiet.
+//It returns false if the changes channel is closed before anything arrives.
+func debounce(changes <-chan string, quiet time.Duration) ([]string, bool) {
+	path, ok := <-changes
+	if !ok {
+		return nil, false
+	}
+	result := []string{path}
+	for {
+		select {
+		case path, ok := <-changes:
+			if !ok {
+				return result, true
+			}
+			result = append(result, path)
+		case <-time.After(quiet):
+			return result, true
+		}
+	}
+}
+
+//refresh applies the policies of target's runners, which rebuilds the images that are out
+//of date.  Unlike Execute this never waits for a container since watch has to get back to
+//watching.
+func (c *Config) refresh(target string, vol *runVolumeSpec) error {
+	pair := strings.Split(strings.Trim(target, " \n"), ".")
+	if len(pair) == 1 {
+		_, err := c.executeTopology(pair[0], vol)
+		return err
+	}
+	info := c.nameToTopology[pair[0]][pair[1]]
+	for i := 0; i < info.instances; i++ {
+		if _, err := info.runner.run(false, c, pair[0], i, vol); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package pickett
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+
+	"github.com/igneous-systems/pickett/io"
+)
+
+func TestAffectedNodes(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+
+	setupForExample1Conf(controller, helper)
+	c, err := NewConfig(strings.NewReader(example1), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	helper.EXPECT().DirectoryRelative("src").Return("/home/gredo/src").AnyTimes()
+	helper.EXPECT().DirectoryRelative("mydir").Return(DIR).AnyTimes()
+
+	nodes := []node{c.nameToNode["blah:bletch"], c.nameToNode["test:nashville"], c.nameToNode["fart:chattanooga"]}
+	check := func(changed string, expected ...string) {
+		names := []string{}
+		for _, n := range c.affectedNodes(nodes, []string{changed}) {
+			names = append(names, n.name())
+		}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("change to %s: expected %v to be affected but got %v", changed, expected, names)
+		}
+	}
+	check(DIR+"/Dockerfile", "blah:bletch")
+	check("/home/gredo/src/p1/sub/x.go", "test:nashville")
+	check("/home/gredo/src/p5/p6/y.go", "fart:chattanooga")
+	//not in any package, so it could be a library either of them uses
+	check("/home/gredo/src/lib/z.go", "test:nashville", "fart:chattanooga")
+	check("/home/gredo/elsewhere/w.go")
+
+	dirs := c.watchDirs(nodes)
+	if strings.Join(dirs, ",") != DIR+",/home/gredo/src" {
+		t.Errorf("wrong directories to watch: %v", dirs)
+	}
+}
+
+func TestForgetIncludesDownstream(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	helper := io.NewMockHelper(controller)
+	cli := io.NewMockDockerCli(controller)
+
+	setupForExample1Conf(controller, helper)
+	c, err := NewConfig(strings.NewReader(example1), helper, cli, nil)
+	if err != nil {
+		t.Fatalf("can't parse legal config file: %v", err)
+	}
+	now := time.Now()
+	for _, name := range []string{"blah:bletch", "test:nashville"} {
+		c.nameToNode[name].(*nodeImpl).tagTime = now
+	}
+	c.nameToNode["blah:bletch"].forget()
+	for _, name := range []string{"blah:bletch", "test:nashville"} {
+		if !c.nameToNode[name].time().IsZero() {
+			t.Errorf("%s was not forgotten", name)
+		}
+	}
+}
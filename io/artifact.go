@@ -0,0 +1,206 @@
+package io
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//CopyArtifact is something to take out of an image (or the source tree, if that's where
+//it is) for an extraction.  SourcePath can be a pattern like /go/bin/*, each file or
+//directory it matches is placed under DestinationDir.
+type CopyArtifact struct {
+	SourcePath, DestinationDir string
+	Rename                     string   //new name of what SourcePath matches, it must match one thing
+	Exclude                    []string //patterns for names (or full paths, if they have a /) to leave out
+	Mode                       int64    //permissions for the files, 0 keeps what they have
+	Owner                      bool     //if true, the files and directories belong to Uid and Gid
+	Uid, Gid                   int
+}
+
+//StaticPrefix returns the part of a path pattern before its first element with a glob
+//character, or the whole path if there is none.  That is what has to be copied to find
+//what the pattern matches.
+func StaticPrefix(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[\\") {
+			if i <= 1 {
+				return "/"
+			}
+			return strings.Join(parts[:i], "/")
+		}
+	}
+	return pattern
+}
+
+//excluded is true if the file or directory at builtPath is left out.
+func (a *CopyArtifact) excluded(builtPath string) bool {
+	for _, pattern := range a.Exclude {
+		candidate := path.Base(builtPath)
+		if strings.Contains(pattern, "/") {
+			candidate = builtPath
+		}
+		if ok, _ := path.Match(pattern, candidate); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//place returns where builtPath goes, relative to DestinationDir, or false if the artifact
+//does not include it.  What the pattern matched keeps its name (or takes Rename), and the
+//things inside it keep their place in it.  matched remembers what the pattern matched,
+//since a rename only makes sense for one thing.
+func (a *CopyArtifact) place(builtPath string, matched *string) (string, bool, error) {
+	top := builtPath
+	for {
+		if ok, _ := path.Match(a.SourcePath, top); ok || top == a.SourcePath {
+			break
+		}
+		if top == "/" || top == "." {
+			return "", false, nil
+		}
+		top = path.Dir(top)
+	}
+	for p := builtPath; ; p = path.Dir(p) {
+		if a.excluded(p) {
+			return "", false, nil
+		}
+		if p == top {
+			break
+		}
+	}
+	name := path.Base(top)
+	if a.Rename != "" {
+		if *matched != "" && *matched != top {
+			return "", false, fmt.Errorf("%s matches more than one thing (%s and %s), can't rename to %s",
+				a.SourcePath, *matched, top, a.Rename)
+		}
+		name = a.Rename
+	}
+	*matched = top
+	return name + builtPath[len(top):], true, nil
+}
+
+//artifactEntries calls fn with each file and directory of an artifact, taken from the
+//source tree if it is in realPathSource and from cont otherwise.
+func (d *dockerCli) artifactEntries(realPathSource map[string]string, cont string, a *CopyArtifact,
+	fn func(*tar.Header, io.Reader) error) error {
+	if truePath, found := realPathSource[a.SourcePath]; found {
+		return a.hostEntries(truePath, fn)
+	}
+	return a.entries(func(each func(*tar.Header, io.Reader) error) error {
+		return d.copyFromContainer(cont, StaticPrefix(a.SourcePath), each)
+	}, fn)
+}
+
+//hostEntries is artifactEntries for an artifact that is at hostPath in the source tree.
+func (a *CopyArtifact) hostEntries(hostPath string, fn func(*tar.Header, io.Reader) error) error {
+	return a.entries(func(each func(*tar.Header, io.Reader) error) error {
+		return walkHost(StaticPrefix(hostPath), path.Base(StaticPrefix(a.SourcePath)), each)
+	}, fn)
+}
+
+//entries calls fn with what source finds that the artifact includes.  source names what
+//it finds relative to the directory of the static part of SourcePath, like docker does.
+//The entries fn gets are named as they are placed, relative to /, with the artifact's mode
+//and owner applied.
+func (a *CopyArtifact) entries(source func(func(*tar.Header, io.Reader) error) error,
+	fn func(*tar.Header, io.Reader) error) error {
+	root := StaticPrefix(a.SourcePath)
+	dest := strings.TrimLeft(path.Clean(a.DestinationDir), "/")
+	matched := ""
+	err := source(func(entry *tar.Header, content io.Reader) error {
+		placed, ok, err := a.place(path.Join(path.Dir(root), entry.Name), &matched)
+		if err != nil || !ok {
+			return err
+		}
+		hdr := *entry
+		hdr.Name = path.Join(dest, placed)
+		if entry.FileInfo().IsDir() {
+			hdr.Name += "/"
+		} else if a.Mode != 0 && (entry.Typeflag == tar.TypeReg || entry.Typeflag == tar.TypeRegA) {
+			hdr.Mode = hdr.Mode&^0777 | a.Mode
+		}
+		if a.Owner {
+			hdr.Uid, hdr.Gid = a.Uid, a.Gid
+			hdr.Uname, hdr.Gname = "", ""
+		}
+		return fn(&hdr, content)
+	})
+	if err != nil {
+		return err
+	}
+	if matched == "" {
+		return fmt.Errorf("nothing matches the artifact %s", a.SourcePath)
+	}
+	return nil
+}
+
+//hashEntry adds an entry to h: where it goes, its mode and owner and, as the returned
+//reader is read, its content.  Modification times are left out, so an artifact that is
+//rebuilt the same has the same digest.
+func hashEntry(h hash.Hash, hdr *tar.Header, content io.Reader) io.Reader {
+	fmt.Fprintf(h, "%s %o %d:%d %s\n", hdr.Name, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Linkname)
+	return io.TeeReader(content, h)
+}
+
+//digestArtifact returns the digest of an artifact that is at hostPath in the source tree,
+//the same digest CmdCopy records for it.
+func digestArtifact(hostPath string, a *CopyArtifact) (string, error) {
+	h := sha1.New()
+	err := a.hostEntries(hostPath, func(hdr *tar.Header, content io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, hashEntry(h, hdr, content))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+//walkHost calls fn with each file and directory under root (or root itself if it is a
+//file), named like docker names what it copies out of a container: relative to root's
+//directory, with root called name.  They belong to root, like the files we send for a
+//docker build.
+func walkHost(root string, name string, fn func(*tar.Header, io.Reader) error) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, filepath.ToSlash(rel))
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if !info.Mode().IsRegular() {
+			return fn(hdr, bytes.NewReader(nil))
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(hdr, f)
+	})
+}
@@ -0,0 +1,170 @@
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+//buildMessage is one message of the json stream docker sends while it builds.
+type buildMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	Progress    string `json:"progress"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+}
+
+//BuildError is a build that docker could not finish.  Step is the Dockerfile step that
+//failed (like "Step 3 : RUN make"), if docker got as far as a step, and Output is what
+//that step printed.
+type BuildError struct {
+	Tag     string
+	Step    string
+	Message string
+	Code    int
+	Output  string
+}
+
+func (b *BuildError) Error() string {
+	if b.Step == "" {
+		return fmt.Sprintf("build of %s failed: %s", b.Tag, b.Message)
+	}
+	return fmt.Sprintf("build of %s failed at '%s': %s", b.Tag, b.Step, b.Message)
+}
+
+//buildOutput serializes what concurrent builds print, so the lines of one build are not
+//cut in half by the lines of another.
+var buildOutput sync.Mutex
+
+//buildProgress follows the messages of one build.  Each step is shown as it starts,
+//prefixed with the tag being built, and what the current step prints is kept in case it
+//fails.  When quiet, nothing is shown unless the build fails.
+type buildProgress struct {
+	tag    string
+	quiet  bool
+	out    io.Writer
+	step   string
+	output []string
+}
+
+func (p *buildProgress) show(format string, args ...interface{}) {
+	buildOutput.Lock()
+	defer buildOutput.Unlock()
+	fmt.Fprintf(p.out, "[%s] "+format+"\n", append([]interface{}{p.tag}, args...)...)
+}
+
+//line handles one line of the stream of the build.
+func (p *buildProgress) line(line string) {
+	line = strings.TrimRight(line, " \r\n")
+	if line == "" {
+		return
+	}
+	flog.Debugf("[%s] %s", p.tag, line)
+	if strings.HasPrefix(line, "Step ") {
+		p.step = line
+		p.output = nil
+		if !p.quiet {
+			p.show("%s", line)
+		}
+		return
+	}
+	p.output = append(p.output, line)
+}
+
+//failed shows the output of the step that failed, quiet or not, and returns the error.
+func (p *buildProgress) failed(msg *buildMessage) error {
+	result := &BuildError{
+		Tag:     p.tag,
+		Step:    p.step,
+		Message: strings.TrimSpace(msg.Error),
+		Code:    msg.ErrorDetail.Code,
+		Output:  strings.Join(p.output, "\n"),
+	}
+	if msg.ErrorDetail.Message != "" {
+		result.Message = strings.TrimSpace(msg.ErrorDetail.Message)
+	}
+	buildOutput.Lock()
+	defer buildOutput.Unlock()
+	if p.quiet && p.step != "" {
+		fmt.Fprintf(p.out, "[%s] %s\n", p.tag, p.step)
+	}
+	for _, line := range p.output {
+		fmt.Fprintf(p.out, "[%s]   %s\n", p.tag, line)
+	}
+	return result
+}
+
+//follow reads the messages of a build from stream until it ends, and returns a
+//*BuildError if docker says the build failed.
+func (p *buildProgress) follow(stream io.Reader) error {
+	dec := json.NewDecoder(stream)
+	partial := ""
+	for {
+		msg := &buildMessage{}
+		if err := dec.Decode(msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("unable to read the progress of the build of %s: %v", p.tag, err)
+		}
+		if msg.Error != "" || msg.ErrorDetail.Message != "" {
+			if partial != "" {
+				p.line(partial)
+			}
+			return p.failed(msg)
+		}
+		if msg.Stream == "" {
+			if msg.Status != "" {
+				flog.Debugf("[%s] %s %s", p.tag, msg.Status, msg.Progress)
+			}
+			continue
+		}
+		//a line may come in more than one message
+		lines := strings.Split(partial+msg.Stream, "\n")
+		partial = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			p.line(line)
+		}
+	}
+	if partial != "" {
+		p.line(partial)
+	}
+	return nil
+}
+
+//build sends the build context (a tarball with a Dockerfile in it) to docker and follows
+//the progress of the build of tag.  The image is labelled as pickett's, and its project's.
+func (d *dockerCli) build(config *BuildConfig, context io.Reader, tag string) error {
+	owner := map[string]string{OWNED_LABEL: "true"}
+	if config.Project != "" {
+		owner[PROJECT_LABEL] = config.Project
+	}
+	labels, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set("t", tag)
+	params.Set("labels", string(labels))
+	if config.NoCache {
+		params.Set("nocache", "1")
+	}
+	if config.RemoveTemporaryContainer {
+		params.Set("rm", "1")
+	}
+	flog.Debugf("[docker cmd] Building image. Name: %s", tag)
+	stream, err := d.raw.stream("POST", "/build?"+params.Encode(), "application/tar", context)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	progress := &buildProgress{tag: tag, quiet: config.Quiet, out: os.Stdout}
+	return progress.follow(stream)
+}
@@ -0,0 +1,207 @@
+package io
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	//DEFAULT_DOCKER_HOST is where docker is when neither DOCKER_HOST nor a context says.
+	DEFAULT_DOCKER_HOST = "unix:///var/run/docker.sock"
+	//DEFAULT_ETCD_ENDPOINT is where etcd is when ETCD_ENDPOINTS does not say.
+	DEFAULT_ETCD_ENDPOINT = "http://127.0.0.1:4001"
+)
+
+//DockerEndpoint is how to reach a docker server.  Host is like tcp://host:2376 or
+//unix:///var/run/docker.sock.  With TLS, the client certificate (cert.pem and key.pem) and
+//the CA (ca.pem) are read from CertPath, and, as with the docker client, the server is only
+//checked against the CA if Verify is set.
+type DockerEndpoint struct {
+	Host     string
+	TLS      bool
+	Verify   bool
+	CertPath string
+}
+
+//dockerConfigDir is where the docker client keeps its configuration, certificates and contexts.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".docker")
+}
+
+//DockerEnvironment returns the docker server to use, worked out the way the docker client
+//does it: DOCKER_HOST (with DOCKER_TLS_VERIFY and DOCKER_CERT_PATH) if it is set, otherwise
+//the context named by DOCKER_CONTEXT or the current context of the docker configuration,
+//otherwise the local socket.
+func DockerEnvironment() (*DockerEndpoint, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		result := &DockerEndpoint{
+			Host:     host,
+			Verify:   os.Getenv("DOCKER_TLS_VERIFY") != "",
+			CertPath: os.Getenv("DOCKER_CERT_PATH"),
+		}
+		result.TLS = result.Verify || result.CertPath != ""
+		if result.TLS && result.CertPath == "" {
+			result.CertPath = dockerConfigDir()
+		}
+		return result, nil
+	}
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		name = currentContext()
+	}
+	if name != "" && name != "default" {
+		return ContextEndpoint(name)
+	}
+	return &DockerEndpoint{Host: DEFAULT_DOCKER_HOST}, nil
+}
+
+//currentContext returns the context the docker configuration says to use, if any.
+func currentContext() string {
+	buf, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if err != nil {
+		return ""
+	}
+	config := struct {
+		CurrentContext string `json:"currentContext"`
+	}{}
+	if err := json.Unmarshal(buf, &config); err != nil {
+		flog.Warningf("ignoring docker configuration we can't understand: %v", err)
+		return ""
+	}
+	return config.CurrentContext
+}
+
+//ContextEndpoint returns the docker server of the docker context name, as created with
+//"docker context create".
+func ContextEndpoint(name string) (*DockerEndpoint, error) {
+	id := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+	buf, err := ioutil.ReadFile(filepath.Join(dockerConfigDir(), "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		return nil, fmt.Errorf("no docker context %s: %v", name, err)
+	}
+	meta := struct {
+		Endpoints map[string]struct {
+			Host          string
+			SkipTLSVerify bool
+		}
+	}{}
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return nil, fmt.Errorf("can't understand docker context %s: %v", name, err)
+	}
+	docker, ok := meta.Endpoints["docker"]
+	if !ok || docker.Host == "" {
+		return nil, fmt.Errorf("docker context %s has no docker endpoint", name)
+	}
+	result := &DockerEndpoint{Host: docker.Host}
+	certs := filepath.Join(dockerConfigDir(), "contexts", "tls", id, "docker")
+	if _, err := os.Stat(certs); err == nil {
+		result.TLS = true
+		result.Verify = !docker.SkipTLSVerify
+		result.CertPath = certs
+	}
+	return result, nil
+}
+
+//url returns the endpoint the way the clients take it: with the default port if there is
+//none, and https if it uses TLS.
+func (e *DockerEndpoint) url() (string, error) {
+	if err := validateEndpoint(e.Host); err != nil {
+		return "", err
+	}
+	u, _ := url.Parse(e.Host)
+	if u.Scheme == "unix" {
+		return e.Host, nil
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		if e.TLS {
+			u.Host = net.JoinHostPort(u.Host, "2376")
+		} else {
+			u.Host = net.JoinHostPort(u.Host, "2375")
+		}
+	}
+	if e.TLS {
+		u.Scheme = "https"
+	}
+	return u.String(), nil
+}
+
+//tlsConfig returns the TLS configuration of the endpoint, nil if it does not use TLS.
+func (e *DockerEndpoint) tlsConfig() (*tls.Config, error) {
+	if !e.TLS {
+		return nil, nil
+	}
+	return newTLSConfig(filepath.Join(e.CertPath, "cert.pem"), filepath.Join(e.CertPath, "key.pem"),
+		filepath.Join(e.CertPath, "ca.pem"), e.Verify)
+}
+
+//newTLSConfig loads a client certificate, if there is one, and the CA to check the server
+//against.  Without verify the server is not checked, and the CA is not needed.  With verify
+//and no caFile, the server is checked against the CAs of the system.
+func newTLSConfig(certFile string, keyFile string, caFile string, verify bool) (*tls.Config, error) {
+	result := &tls.Config{InsecureSkipVerify: !verify}
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load the client certificate %s: %v", certFile, err)
+		}
+		result.Certificates = []tls.Certificate{cert}
+	}
+	if !verify || caFile == "" {
+		return result, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the CA to verify the server with: %v", err)
+	}
+	result.RootCAs = x509.NewCertPool()
+	if !result.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return result, nil
+}
+
+//etcdEnvironment returns the etcd endpoints of ETCD_ENDPOINTS (comma separated) and, if
+//any of ETCD_CERT_FILE, ETCD_KEY_FILE or ETCD_CA_FILE are set, the TLS configuration to
+//talk to them with.  The server is always verified, against the CA if there is one and the
+//CAs of the system otherwise.
+func etcdEnvironment() ([]string, *tls.Config, error) {
+	endpoints := []string{}
+	for _, e := range strings.Split(os.Getenv("ETCD_ENDPOINTS"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	if len(endpoints) == 0 {
+		endpoints = []string{DEFAULT_ETCD_ENDPOINT}
+	}
+	cert, key, ca := os.Getenv("ETCD_CERT_FILE"), os.Getenv("ETCD_KEY_FILE"), os.Getenv("ETCD_CA_FILE")
+	if cert == "" && key == "" && ca == "" {
+		return endpoints, nil, nil
+	}
+	if (cert == "") != (key == "") {
+		return nil, nil, fmt.Errorf("ETCD_CERT_FILE and ETCD_KEY_FILE go together")
+	}
+	//newTLSConfig does without a client certificate that isn't there, but this one was asked for
+	if cert != "" {
+		if _, err := os.Stat(cert); err != nil {
+			return nil, nil, fmt.Errorf("can't use ETCD_CERT_FILE: %v", err)
+		}
+	}
+	config, err := newTLSConfig(cert, key, ca, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return endpoints, config, nil
+}
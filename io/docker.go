@@ -3,9 +3,12 @@ package io
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -38,12 +41,18 @@ type PortBinding struct {
 type RunConfig struct {
 	Image      string
 	Attach     bool
+	Env        []string //like FOO=bar
+	WorkDir    string
 	Volumes    map[string]string
 	Ports      map[Port][]PortBinding
 	Devices    map[string]string
 	Links      map[string]string
 	Privileged bool
 	WaitOutput bool
+	Temporary  bool   //the container is only used while we run, pickett gc removes leftovers
+	Project    string //labelled as PROJECT_LABEL, if not empty
+	Labels     map[string]string
+	ExtraHosts []string //like name:ip, added to /etc/hosts of the container
 }
 
 type TagInfo struct {
@@ -54,12 +63,23 @@ type TagInfo struct {
 type BuildConfig struct {
 	NoCache                  bool
 	RemoveTemporaryContainer bool
+	Quiet                    bool   //only show the output of a build that fails
+	Project                  string //labelled as PROJECT_LABEL, if not empty
 }
 
-type CopyArtifact struct {
-	SourcePath, DestinationDir string
+//StopConfig controls how a container is stopped.  Signal is sent first (SIGTERM if it
+//is empty) and the container is killed if it has not exited after Timeout seconds.
+type StopConfig struct {
+	Timeout uint
+	Signal  string
 }
 
+const (
+	DEFAULT_STOP_TIMEOUT = 2
+	ARTIFACT_TARBALL     = "pickett-artifacts.tar"
+	ARTIFACTS_LABEL      = "pickett.artifacts" //json of the digest of each artifact, by SourcePath
+)
+
 type DockerCli interface {
 	CmdRun(*RunConfig, ...string) (*bytes.Buffer, string, error)
 	CmdTag(string, bool, *TagInfo) error
@@ -68,15 +88,29 @@ type DockerCli interface {
 	//Copy actually does two different things: copies artifacts from the source tree into a tarball
 	//or copies artifacts from a container (given here as an image) into a tarball.  In both cases
 	//the resulting tarball is sent to the docker server for a build.
-	CmdCopy(map[string]string, string, string, []*CopyArtifact, string) error
-	CmdLastModTime(map[string]string, string, []*CopyArtifact) (time.Time, error)
-	CmdStop(string) error
+	//The result image is labelled with the labels given and the digest of each artifact.
+	CmdCopy(*BuildConfig, map[string]string, string, string, []*CopyArtifact, string, map[string]string) error
+	//Export gets the artifacts the same way as Copy but writes them to a tarball instead.
+	CmdExport(map[string]string, string, []*CopyArtifact, io.Writer) error
+	CmdStop(string, *StopConfig) error
+	CmdExec(string, ...string) (*bytes.Buffer, error)
 	CmdRmContainer(string) error
 	CmdRmImage(string) error
+	CmdCreateVolume(string, map[string]string) error
+	CmdListVolumes(string) ([]*VolumeInfo, error)
+	CmdRmVolume(string) error
+	ListTemporaryContainers(string) ([]*ContainerInfo, error)
+	ListDanglingImages(string) ([]string, error)
+	ListProjectContainers(string) ([]*ContainerInfo, error)
+	ListAllContainers() ([]*ContainerInfo, error)
+	ListProjectImages(string) ([]*ImageInfo, error)
 	InspectImage(string) (InspectedImage, error)
+	ImageLabels(string) (map[string]string, error)
 	InspectContainer(string) (InspectedContainer, error)
 	ListContainers() (apiContainers, error)
 	ListImages() (apiImages, error)
+	SaveImage(string) (io.ReadCloser, error)
+	LoadImage(io.Reader) error
 }
 
 type InspectedImage interface {
@@ -93,36 +127,67 @@ type InspectedContainer interface {
 	ExitStatus() int
 	Ip() string
 	Ports() []string
+	ImageID() string
+	PortMap() map[string][]string
 }
 
-//NewDocker returns a connection to the docker server.  Pickett assumes that
-//the DockerCli is "passed in from the outside".
+//NewDocker returns a connection to the docker server the environment names (see
+//DockerEnvironment).  Pickett assumes that the DockerCli is "passed in from the outside".
 func NewDockerCli() (DockerCli, error) {
 	if err := validateDockerHost(); err != nil {
 		return nil, err
 	}
-	return newDockerCli()
+	endpoint, err := DockerEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	return newDockerCli(endpoint)
+}
+
+//NewDockerCliFor returns a connection to the docker server at endpoint.  Topology nodes
+//placed on other hosts are run through these.
+func NewDockerCliFor(endpoint *DockerEndpoint) (DockerCli, error) {
+	if err := validateEndpoint(endpoint.Host); err != nil {
+		return nil, fmt.Errorf("%s: %v", endpoint.Host, err)
+	}
+	return newDockerCli(endpoint)
 }
 
 type dockerCli struct {
 	client *docker.Client
+	raw    *rawClient
+	tls    bool
 }
 
 // newDockerCli builds a new docker interface and returns it. It
-// assumes that the DOCKER_HOST env var has already been
-// validated.
-func newDockerCli() (DockerCli, error) {
-	result := &dockerCli{}
-	var err error
-	result.client, err = docker.NewClient(os.Getenv("DOCKER_HOST"))
+// assumes that the endpoint has already been validated.
+func newDockerCli(endpoint *DockerEndpoint) (DockerCli, error) {
+	host, err := endpoint.url()
+	if err != nil {
+		return nil, err
+	}
+	config, err := endpoint.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	result := &dockerCli{tls: config != nil}
+	result.client, err = docker.NewClient(host)
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		result.client.HTTPClient = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+	result.raw, err = newRawClient(host, config)
 	if err != nil {
 		return nil, err
 	}
-	flog.Debugf("[docker cmd] export DOCKER_HOST='%s'", os.Getenv("DOCKER_HOST"))
+	flog.Debugf("[docker cmd] export DOCKER_HOST='%s' DOCKER_TLS_VERIFY='%v' DOCKER_CERT_PATH='%s'",
+		endpoint.Host, endpoint.Verify, endpoint.CertPath)
 	return result, nil
 }
 
-func (d *dockerCli) createNamedContainer(config *docker.Config) (*docker.Container, error) {
+func (d *dockerCli) createNamedContainer(config *docker.Config, labels map[string]string) (*docker.Container, error) {
 	tries := 0
 	ok := false
 	var cont *docker.Container
@@ -133,7 +198,7 @@ func (d *dockerCli) createNamedContainer(config *docker.Config) (*docker.Contain
 		opts.Name = newPhrase()
 		flog.Debugf("[docker cmd] Attempting to create container %s (%d) from image: %s", opts.Name, tries, opts.Config.Image)
 
-		cont, err = d.client.CreateContainer(opts)
+		cont, err = d.createContainer(opts, labels)
 		if err != nil {
 			detail, ok := err.(*docker.Error)
 			if ok && detail.Status == 409 {
@@ -151,7 +216,7 @@ func (d *dockerCli) createNamedContainer(config *docker.Config) (*docker.Contain
 		opts.Name = newPhrase()
 		flog.Debugf("[docker cmd] Creating container named: %s", opts.Name)
 
-		cont, err = d.client.CreateContainer(opts)
+		cont, err = d.createContainer(opts, labels)
 		if err != nil {
 			return nil, err
 		}
@@ -165,13 +230,51 @@ func (fakeStdin) Read(p []byte) (int, error) {
 	select {}
 }
 
+//attach copies what the container has written, and if stream is set what it writes until it
+//exits, to stdout and stderr.  The vendored client can't hijack a connection over TLS, so
+//with TLS this is a plain request that docker answers with the output.
+func (d *dockerCli) attach(id string, stream bool, stdout io.Writer, stderr io.Writer) error {
+	if d.tls {
+		return d.raw.attach(id, stream, stdout, stderr)
+	}
+	opts := docker.AttachToContainerOptions{
+		Container:    id,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Logs:         true,
+		Stdout:       true,
+		Stderr:       true,
+	}
+	if stream {
+		opts.InputStream = fakeStdin(0)
+		opts.Stdin = true
+		opts.Stream = true
+	}
+	return d.client.AttachToContainer(opts)
+}
+
 func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, string, error) {
 	config := &docker.Config{}
 	config.Cmd = s
 	config.Image = runconf.Image
+	config.Env = runconf.Env
+	config.WorkingDir = runconf.WorkDir
+
+	//images committed from temporary containers by older picketts have the label too, so the
+	//containers that are not temporary have to say so
+	labels := map[string]string{TEMPORARY_LABEL: NOT_TEMPORARY}
+	if runconf.Temporary {
+		labels[TEMPORARY_LABEL] = "run"
+	}
+	if runconf.Project != "" {
+		labels[PROJECT_LABEL] = runconf.Project
+	}
+	for k, v := range runconf.Labels {
+		labels[k] = v
+	}
 
 	fordebug := new(bytes.Buffer)
-	cont, err := d.createNamedContainer(config)
+	cont, err := d.createNamedContainer(config, labels)
 	if err != nil {
 		return nil, "", err
 	}
@@ -185,6 +288,9 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 		fordebug.WriteString(fmt.Sprintf("--link %s:%s ", k, v))
 	}
 	host.Links = flatLinks
+	for _, e := range runconf.Env {
+		fordebug.WriteString(fmt.Sprintf("-e %s ", e))
+	}
 	host.Binds = []string{}
 	for k, v := range runconf.Volumes {
 		host.Binds = append(host.Binds, fmt.Sprintf("%s:%s", k, v))
@@ -215,10 +321,17 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 	host.PortBindings = convertedMap
 
 	host.Privileged = runconf.Privileged
+	for _, h := range runconf.ExtraHosts {
+		fordebug.WriteString(fmt.Sprintf("--add-host %s ", h))
+	}
 
 	flog.Debugf("[docker cmd] %s%s", fordebug.Bytes(), strings.Join(config.Cmd, " "))
 
-	err = d.client.StartContainer(cont.ID, host)
+	if len(runconf.ExtraHosts) > 0 {
+		err = d.startWithExtraHosts(cont.ID, host, runconf.ExtraHosts)
+	} else {
+		err = d.client.StartContainer(cont.ID, host)
+	}
 	if err != nil {
 		return nil, "", err
 	}
@@ -227,17 +340,7 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 		//These are the right settings if you want to "watch" the output of the command and wait for
 		//it to terminate
 
-		err = d.client.AttachToContainer(docker.AttachToContainerOptions{
-			Container:    cont.ID,
-			InputStream:  fakeStdin(0),
-			OutputStream: os.Stdout,
-			ErrorStream:  os.Stderr,
-			Logs:         true,
-			Stdin:        true,
-			Stdout:       true,
-			Stderr:       true,
-			Stream:       true,
-		})
+		err = d.attach(cont.ID, true, os.Stdout, os.Stderr)
 
 		if err != nil {
 			return nil, "", err
@@ -251,7 +354,8 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 			if err != nil {
 				return nil, "", err
 			} else if status != 0 {
-				return nil, "", fmt.Errorf("Non-zero exitcode %v from %v", status, cont.Name)
+				//the id is returned so the caller can clean up the container
+				return nil, cont.ID, fmt.Errorf("Non-zero exitcode %v from %v", status, cont.Name)
 			}
 		}
 
@@ -264,14 +368,7 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 			return nil, "", err
 		}
 		out := new(bytes.Buffer)
-		err = d.client.AttachToContainer(docker.AttachToContainerOptions{
-			Container:    cont.ID,
-			OutputStream: out,
-			ErrorStream:  out,
-			Logs:         true,
-			Stdout:       true,
-			Stderr:       true,
-		})
+		err = d.attach(cont.ID, false, out, out)
 		if err != nil {
 			return nil, "", err
 		}
@@ -283,9 +380,108 @@ func (d *dockerCli) CmdRun(runconf *RunConfig, s ...string) (*bytes.Buffer, stri
 	return nil, cont.ID, nil
 }
 
-func (d *dockerCli) CmdStop(contID string) error {
-	flog.Debugf("Stopping container %s\n", contID)
-	return d.client.StopContainer(contID, 2)
+//CmdStop sends the configured signal to the container and kills it if it has not exited
+//when the timeout runs out.  A nil config means SIGTERM and DEFAULT_STOP_TIMEOUT.
+func (d *dockerCli) CmdStop(contID string, conf *StopConfig) error {
+	if conf == nil {
+		conf = &StopConfig{Timeout: DEFAULT_STOP_TIMEOUT}
+	}
+	sig, err := parseSignal(conf.Signal)
+	if err != nil {
+		return err
+	}
+	if sig == docker.SIGTERM {
+		flog.Debugf("Stopping container %s (timeout %ds)\n", contID, conf.Timeout)
+		return d.client.StopContainer(contID, conf.Timeout)
+	}
+
+	flog.Debugf("Stopping container %s with signal %d (timeout %ds)\n", contID, sig, conf.Timeout)
+	err = d.client.KillContainer(docker.KillContainerOptions{ID: contID, Signal: sig})
+	if err != nil {
+		return err
+	}
+	//buffered, so the goroutine can finish after we stop waiting for it
+	exited := make(chan error, 1)
+	go func() {
+		_, err := d.client.WaitContainer(contID)
+		exited <- err
+	}()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(time.Duration(conf.Timeout) * time.Second):
+		flog.Debugf("container %s did not exit after %ds, killing it", contID, conf.Timeout)
+		return d.client.KillContainer(docker.KillContainerOptions{ID: contID, Signal: docker.SIGKILL})
+	}
+}
+
+var signals = map[string]docker.Signal{
+	"HUP":  docker.SIGHUP,
+	"INT":  docker.SIGINT,
+	"QUIT": docker.SIGQUIT,
+	"KILL": docker.SIGKILL,
+	"USR1": docker.SIGUSR1,
+	"USR2": docker.SIGUSR2,
+	"TERM": docker.SIGTERM,
+	"STOP": docker.SIGSTOP,
+	"PWR":  docker.SIGPWR,
+}
+
+//parseSignal understands signals by name (SIGINT or INT) or number.  An empty
+//string is SIGTERM.
+func parseSignal(name string) (docker.Signal, error) {
+	if name == "" {
+		return docker.SIGTERM, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return docker.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %s", name)
+	}
+	return sig, nil
+}
+
+//ValidateSignal returns an error if name is not a signal that CmdStop understands.
+func ValidateSignal(name string) error {
+	_, err := parseSignal(name)
+	return err
+}
+
+//CmdExec runs a command inside a running container and waits for it to finish.  The
+//output (both stdout and stderr) is returned.  A non-zero exit is an error.
+func (d *dockerCli) CmdExec(contID string, cmd ...string) (*bytes.Buffer, error) {
+	flog.Debugf("[docker cmd] docker exec %s %s", contID, strings.Join(cmd, " "))
+	var created struct {
+		Id string
+	}
+	create := map[string]interface{}{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+	}
+	if err := d.raw.callJSON("POST", "/containers/"+contID+"/exec", create, &created); err != nil {
+		return nil, err
+	}
+	raw, err := d.raw.call("POST", "/exec/"+created.Id+"/start", map[string]bool{"Detach": false, "Tty": false})
+	if err != nil {
+		return nil, err
+	}
+	out := new(bytes.Buffer)
+	if err := demux(raw, out); err != nil {
+		return nil, err
+	}
+	var result struct {
+		ExitCode int
+	}
+	if err := d.raw.callJSON("GET", "/exec/"+created.Id+"/json", nil, &result); err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return out, fmt.Errorf("Non-zero exitcode %v from exec of %v in %s", result.ExitCode, cmd, contID)
+	}
+	return out, nil
 }
 
 func (d *dockerCli) CmdRmImage(imgID string) error {
@@ -312,23 +508,34 @@ func (d *dockerCli) CmdTag(image string, force bool, info *TagInfo) error {
 	})
 }
 
+//CmdCommit makes an image of the container.  The image says it is not temporary, whatever
+//the container was, since containers run of the image later are not pickett's to remove.
 func (d *dockerCli) CmdCommit(containerId string, info *TagInfo) (string, error) {
-	opts := docker.CommitContainerOptions{
-		Container: containerId,
+	labels, err := d.containerLabels(containerId)
+	if err != nil {
+		return "", err
 	}
+	labels[TEMPORARY_LABEL] = NOT_TEMPORARY
+	params := url.Values{}
+	params.Set("container", containerId)
 	if info != nil {
-		opts.Tag = info.Tag
-		opts.Repository = info.Repository
+		params.Set("repo", info.Repository)
+		params.Set("tag", info.Tag)
 	}
 
-	flog.Debugf("[docker cmd] Commit of container. Options: Container: %s, Tag: %s, Repo: %s", opts.Container, opts.Tag, opts.Repository)
+	flog.Debugf("[docker cmd] Commit of container. Options: %s", params.Encode())
 
-	image, err := d.client.CommitContainer(opts)
-	if err != nil {
+	//the rest of the config is the container's, but the labels are only what we give
+	var result struct {
+		Id string
+	}
+	body := struct {
+		Labels map[string]string
+	}{labels}
+	if err := d.raw.callJSON("POST", "/commit?"+params.Encode(), body, &result); err != nil {
 		return "", err
 	}
-
-	return image.ID, nil
+	return result.Id, nil
 }
 
 func (d *dockerCli) tarball(pathToDir string, localName string, tw *tar.Writer) error {
@@ -401,168 +608,66 @@ func (d *dockerCli) writeFullFile(tw *tar.Writer, path string, localName string)
 	return true, nil
 }
 
-//XXX is it safe to use /bin/true?
-func (d *dockerCli) makeDummyContainerToGetAtImage(img string) (string, error) {
-	cont, err := d.client.CreateContainer(docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Image:      img,
-			Entrypoint: []string{"/bin/true"},
-		},
+//copyFromContainer reads resource out of cont and calls fn with each entry of the tarball
+//docker gives us for it.  Entries are named relative to the directory resource is in.
+func (d *dockerCli) copyFromContainer(cont string, resource string, fn func(*tar.Header, io.Reader) error) error {
+	buf := new(bytes.Buffer)
+	err := d.client.CopyFromContainer(docker.CopyFromContainerOptions{
+		OutputStream: buf,
+		Container:    cont,
+		Resource:     resource,
 	})
 	if err != nil {
-		return "", err
-	}
-	return cont.ID, nil
-}
-
-func (d *dockerCli) CmdLastModTime(realPathSource map[string]string, img string,
-	artifacts []*CopyArtifact) (time.Time, error) {
-	if len(realPathSource) == len(artifacts) {
-		flog.Debugln("no work to do in the container for last mod time, no artifacts inside it.")
-		return time.Time{}, nil
-	}
-	cont, err := d.makeDummyContainerToGetAtImage(img)
-	if err != nil {
-		return time.Time{}, err
-	}
-	err = d.client.StartContainer(cont, &docker.HostConfig{})
-	if err != nil {
-		return time.Time{}, err
+		return err
 	}
-	//walk each artifact, getting it from the container, skipping sources
-	best := time.Time{}
-	for _, a := range artifacts {
-		_, found := realPathSource[a.SourcePath]
-		if found {
-			continue
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		entry, err := tr.Next()
+		if err == io.EOF {
+			return nil
 		}
-		//pull it from container
-		buf := new(bytes.Buffer)
-		flog.Debugf("copying from container %s. Resource %s to %s", cont, a.SourcePath, a.DestinationDir)
-
-		err = d.client.CopyFromContainer(docker.CopyFromContainerOptions{
-			OutputStream: buf,
-			Container:    cont,
-			Resource:     a.SourcePath,
-		})
 		if err != nil {
-			return time.Time{}, err
+			return err
 		}
-		//var out bytes.Buffer
-		r := bytes.NewReader(buf.Bytes())
-		tr := tar.NewReader(r)
-		for {
-			entry, err := tr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return time.Time{}, err
-			}
-			flog.Debugf("read file from container: %s, %v", entry.Name, entry.ModTime)
-			if !entry.FileInfo().IsDir() {
-				if entry.ModTime.After(best) {
-					best = entry.ModTime
-				}
-			}
+		if err := fn(entry, tr); err != nil {
+			return err
 		}
 	}
-	return best, nil
 }
 
-//
-// HACK! Poll the server until the image is built.
-//
-func hacky_poll(d *dockerCli) chan struct{} {
-	term := make(chan struct{})
-	go func() {
-		for {
-			d.client.Ping()
-			select {
-			case <-time.After(300 * time.Millisecond):
-			case <-term:
-				return
-			}
-		}
-	}()
-	return term
-}
-
-func (d *dockerCli) CmdCopy(realPathSource map[string]string, imgSrc string, imgDest string,
-	artifacts []*CopyArtifact, resultTag string) error {
-	cont, err := d.makeDummyContainerToGetAtImage(imgSrc)
+func (d *dockerCli) CmdCopy(config *BuildConfig, realPathSource map[string]string, imgSrc string, imgDest string,
+	artifacts []*CopyArtifact, resultTag string, labels map[string]string) error {
+	//everything goes in one tarball that ADD unpacks, so the result is one layer and keeps
+	//the directories, modes and owners of the artifacts
+	artifactTarball := new(bytes.Buffer)
+	digests, err := d.artifactTarball(realPathSource, imgSrc, artifacts, artifactTarball)
 	if err != nil {
 		return err
 	}
-
-	if len(realPathSource) != len(artifacts) {
-		flog.Debugln("starting container because we need to retrieve artifacts from it")
-		//don't bother starting the container untless there is something we need from it
-		err = d.client.StartContainer(cont, &docker.HostConfig{})
-		if err != nil {
-			return err
-		}
-	} else {
-		flog.Debugln("all artifacts found in source tree, not starting container")
+	digestLabel, err := json.Marshal(digests)
+	if err != nil {
+		return err
 	}
 
 	dockerFile := new(bytes.Buffer)
+	dockerFile.WriteString(fmt.Sprintf("FROM %s\nADD %s /\n", imgDest, ARTIFACT_TARBALL))
+	dockerFile.WriteString(fmt.Sprintf("LABEL %s=%s", ARTIFACTS_LABEL, strconv.Quote(string(digestLabel))))
+	for k, v := range labels {
+		dockerFile.WriteString(fmt.Sprintf(" %s=%s", k, strconv.Quote(v)))
+	}
+	dockerFile.WriteString("\n")
 	resulTarball := new(bytes.Buffer)
 	tw := tar.NewWriter(resulTarball)
-
-	dockerFile.WriteString(fmt.Sprintf("FROM %s\n", imgDest))
-
-	//walk each artifact, potentially getting it from the container
-	for _, a := range artifacts {
-		truePath, found := realPathSource[a.SourcePath]
-		if found {
-			isFile, err := d.writeFullFile(tw, truePath, a.SourcePath)
-			if err != nil {
-				return err
-			}
-			//kinda hacky: we use a.SourcePath as the name *inside* the tarball so we can get the
-			//directory name right on the final output
-			flog.Debugf("COPY %s TO %s.", a.SourcePath, a.DestinationDir)
-			dockerFile.WriteString(fmt.Sprintf("COPY %s %s\n", a.SourcePath, a.DestinationDir))
-			if !isFile {
-				if err := d.tarball(truePath, a.SourcePath, tw); err != nil {
-					return err
-				}
-			}
-		} else {
-			//pull it from container
-			buf := new(bytes.Buffer)
-			err = d.client.CopyFromContainer(docker.CopyFromContainerOptions{
-				OutputStream: buf,
-				Container:    cont,
-				Resource:     a.SourcePath,
-			})
-			if err != nil {
-				return err
-			}
-			//var out bytes.Buffer
-			r := bytes.NewReader(buf.Bytes())
-			tr := tar.NewReader(r)
-			for {
-				entry, err := tr.Next()
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					return err
-				}
-				flog.Debugf("read file from container: %s", entry.Name)
-				if !entry.FileInfo().IsDir() {
-					dockerFile.WriteString(fmt.Sprintf("COPY %s %s\n", entry.Name, a.DestinationDir+"/"+entry.Name))
-					if err := tw.WriteHeader(entry); err != nil {
-						return err
-					}
-					if _, err := io.Copy(tw, tr); err != nil {
-						return err
-					}
-				}
-			}
-		}
+	atHdr := &tar.Header{
+		Name: ARTIFACT_TARBALL,
+		Mode: 0644,
+		Size: int64(artifactTarball.Len()),
+	}
+	if err := tw.WriteHeader(atHdr); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, artifactTarball); err != nil {
+		return err
 	}
 
 	hdr := &tar.Header{
@@ -579,25 +684,7 @@ func (d *dockerCli) CmdCopy(realPathSource map[string]string, imgSrc string, img
 		return err
 	}
 
-	opts := docker.BuildImageOptions{
-		Name:           resultTag,
-		InputStream:    resulTarball,
-		OutputStream:   os.Stdout,
-		RmTmpContainer: true,
-		SuppressOutput: false,
-		NoCache:        true,
-	}
-
-	flog.Debugf("[docker cmd] Building image. Name: %s", opts.Name)
-
-	term := hacky_poll(d)
-	defer close(term)
-
-	if err := d.client.BuildImage(opts); err != nil {
-		return err
-	}
-
-	return nil
+	return d.build(config, resulTarball, resultTag)
 }
 
 func (d *dockerCli) CmdBuild(config *BuildConfig, pathToDir string, tag string) error {
@@ -612,23 +699,7 @@ func (d *dockerCli) CmdBuild(config *BuildConfig, pathToDir string, tag string)
 	if err := tw.Close(); err != nil {
 		return err
 	}
-	opts := docker.BuildImageOptions{
-		Name:           tag,
-		InputStream:    bytes.NewBuffer(out.Bytes()),
-		OutputStream:   os.Stdout,
-		RmTmpContainer: config.RemoveTemporaryContainer,
-		SuppressOutput: false,
-		NoCache:        config.NoCache,
-	}
-
-	term := hacky_poll(d)
-	defer close(term)
-
-	flog.Debugf("[docker cmd] Building image. Name: %s", opts.Name)
-	if err := d.client.BuildImage(opts); err != nil {
-		return err
-	}
-	return nil
+	return d.build(config, out, tag)
 }
 
 func (c *dockerCli) InspectImage(n string) (InspectedImage, error) {
@@ -652,6 +723,18 @@ func (c *dockerCli) InspectContainer(n string) (InspectedContainer, error) {
 	}, nil
 }
 
+//IsNoSuchContainer is true if err, from InspectContainer, says there is no such container,
+//as opposed to docker being unable to say.
+func IsNoSuchContainer(err error) bool {
+	switch e := err.(type) {
+	case *docker.NoSuchContainer:
+		return true
+	case *docker.Error:
+		return e.Status == http.StatusNotFound
+	}
+	return false
+}
+
 func (d *dockerCli) ListContainers() (apiContainers, error) {
 	containers, err := d.client.ListContainers(docker.ListContainersOptions{All: true})
 	return containers, err
@@ -686,6 +769,23 @@ func (c *contInspect) Ports() []string {
 	return ports
 }
 
+//PortMap returns the container's ports (like 80/tcp) and the host ip:port each is
+//published on, if any.
+func (c *contInspect) PortMap() map[string][]string {
+	result := make(map[string][]string)
+	for k, bindings := range c.wrapped.NetworkSettings.Ports {
+		result[string(k)] = []string{}
+		for _, b := range bindings {
+			result[string(k)] = append(result[string(k)], b.HostIp+":"+b.HostPort)
+		}
+	}
+	return result
+}
+
+func (c *contInspect) ImageID() string {
+	return c.wrapped.Image
+}
+
 func (c *contInspect) CreatedTime() time.Time {
 	return c.wrapped.Created
 }
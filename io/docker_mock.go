@@ -6,6 +6,7 @@ package io
 import (
 	bytes "bytes"
 	gomock "code.google.com/p/gomock/gomock"
+	io "io"
 	time "time"
 )
 
@@ -78,35 +79,50 @@ func (_mr *_MockDockerCliRecorder) CmdBuild(arg0, arg1, arg2 interface{}) *gomoc
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdBuild", arg0, arg1, arg2)
 }
 
-func (_m *MockDockerCli) CmdCopy(_param0 map[string]string, _param1 string, _param2 string, _param3 []*CopyArtifact, _param4 string) error {
-	ret := _m.ctrl.Call(_m, "CmdCopy", _param0, _param1, _param2, _param3, _param4)
+func (_m *MockDockerCli) CmdCopy(_param0 *BuildConfig, _param1 map[string]string, _param2 string, _param3 string, _param4 []*CopyArtifact, _param5 string, _param6 map[string]string) error {
+	ret := _m.ctrl.Call(_m, "CmdCopy", _param0, _param1, _param2, _param3, _param4, _param5, _param6)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockDockerCliRecorder) CmdCopy(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCopy", arg0, arg1, arg2, arg3, arg4)
+func (_mr *_MockDockerCliRecorder) CmdCopy(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCopy", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
 }
 
-func (_m *MockDockerCli) CmdLastModTime(_param0 map[string]string, _param1 string, _param2 []*CopyArtifact) (time.Time, error) {
-	ret := _m.ctrl.Call(_m, "CmdLastModTime", _param0, _param1, _param2)
-	ret0, _ := ret[0].(time.Time)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+func (_m *MockDockerCli) CmdExport(_param0 map[string]string, _param1 string, _param2 []*CopyArtifact, _param3 io.Writer) error {
+	ret := _m.ctrl.Call(_m, "CmdExport", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
-func (_mr *_MockDockerCliRecorder) CmdLastModTime(arg0, arg1, arg2 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdLastModTime", arg0, arg1, arg2)
+func (_mr *_MockDockerCliRecorder) CmdExport(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdExport", arg0, arg1, arg2, arg3)
 }
 
-func (_m *MockDockerCli) CmdStop(_param0 string) error {
-	ret := _m.ctrl.Call(_m, "CmdStop", _param0)
+func (_m *MockDockerCli) CmdStop(_param0 string, _param1 *StopConfig) error {
+	ret := _m.ctrl.Call(_m, "CmdStop", _param0, _param1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockDockerCliRecorder) CmdStop(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdStop", arg0)
+func (_mr *_MockDockerCliRecorder) CmdStop(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdStop", arg0, arg1)
+}
+
+func (_m *MockDockerCli) CmdExec(_param0 string, _param1 ...string) (*bytes.Buffer, error) {
+	_s := []interface{}{_param0}
+	for _, _x := range _param1 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "CmdExec", _s...)
+	ret0, _ := ret[0].(*bytes.Buffer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) CmdExec(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0}, arg1...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdExec", _s...)
 }
 
 func (_m *MockDockerCli) CmdRmContainer(_param0 string) error {
@@ -129,6 +145,37 @@ func (_mr *_MockDockerCliRecorder) CmdRmImage(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdRmImage", arg0)
 }
 
+func (_m *MockDockerCli) CmdCreateVolume(_param0 string, _param1 map[string]string) error {
+	ret := _m.ctrl.Call(_m, "CmdCreateVolume", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDockerCliRecorder) CmdCreateVolume(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdCreateVolume", arg0, arg1)
+}
+
+func (_m *MockDockerCli) CmdListVolumes(_param0 string) ([]*VolumeInfo, error) {
+	ret := _m.ctrl.Call(_m, "CmdListVolumes", _param0)
+	ret0, _ := ret[0].([]*VolumeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) CmdListVolumes(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdListVolumes", arg0)
+}
+
+func (_m *MockDockerCli) CmdRmVolume(_param0 string) error {
+	ret := _m.ctrl.Call(_m, "CmdRmVolume", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDockerCliRecorder) CmdRmVolume(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CmdRmVolume", arg0)
+}
+
 func (_m *MockDockerCli) InspectImage(_param0 string) (InspectedImage, error) {
 	ret := _m.ctrl.Call(_m, "InspectImage", _param0)
 	ret0, _ := ret[0].(InspectedImage)
@@ -314,3 +361,110 @@ func (_m *MockInspectedContainer) Ports() []string {
 func (_mr *_MockInspectedContainerRecorder) Ports() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Ports")
 }
+
+func (_m *MockInspectedContainer) ImageID() string {
+	ret := _m.ctrl.Call(_m, "ImageID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockInspectedContainerRecorder) ImageID() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ImageID")
+}
+
+func (_m *MockInspectedContainer) PortMap() map[string][]string {
+	ret := _m.ctrl.Call(_m, "PortMap")
+	ret0, _ := ret[0].(map[string][]string)
+	return ret0
+}
+
+func (_mr *_MockInspectedContainerRecorder) PortMap() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PortMap")
+}
+
+func (_m *MockDockerCli) ListTemporaryContainers(_param0 string) ([]*ContainerInfo, error) {
+	ret := _m.ctrl.Call(_m, "ListTemporaryContainers", _param0)
+	ret0, _ := ret[0].([]*ContainerInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListTemporaryContainers(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListTemporaryContainers", arg0)
+}
+
+func (_m *MockDockerCli) ListProjectContainers(_param0 string) ([]*ContainerInfo, error) {
+	ret := _m.ctrl.Call(_m, "ListProjectContainers", _param0)
+	ret0, _ := ret[0].([]*ContainerInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListProjectContainers(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListProjectContainers", arg0)
+}
+
+func (_m *MockDockerCli) ListAllContainers() ([]*ContainerInfo, error) {
+	ret := _m.ctrl.Call(_m, "ListAllContainers")
+	ret0, _ := ret[0].([]*ContainerInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListAllContainers() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListAllContainers")
+}
+
+func (_m *MockDockerCli) SaveImage(_param0 string) (io.ReadCloser, error) {
+	ret := _m.ctrl.Call(_m, "SaveImage", _param0)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) SaveImage(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SaveImage", arg0)
+}
+
+func (_m *MockDockerCli) LoadImage(_param0 io.Reader) error {
+	ret := _m.ctrl.Call(_m, "LoadImage", _param0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDockerCliRecorder) LoadImage(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LoadImage", arg0)
+}
+
+func (_m *MockDockerCli) ListProjectImages(_param0 string) ([]*ImageInfo, error) {
+	ret := _m.ctrl.Call(_m, "ListProjectImages", _param0)
+	ret0, _ := ret[0].([]*ImageInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListProjectImages(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListProjectImages", arg0)
+}
+
+func (_m *MockDockerCli) ListDanglingImages(_param0 string) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "ListDanglingImages", _param0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ListDanglingImages(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListDanglingImages", arg0)
+}
+
+func (_m *MockDockerCli) ImageLabels(_param0 string) (map[string]string, error) {
+	ret := _m.ctrl.Call(_m, "ImageLabels", _param0)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDockerCliRecorder) ImageLabels(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ImageLabels", arg0)
+}
@@ -1,6 +1,8 @@
 package io
 
 import (
+	"fmt"
+	"net/http"
 	"path/filepath"
 	"strings"
 
@@ -13,6 +15,11 @@ type EtcdClient interface {
 	Del(string) (string, error)
 	Children(string) ([]string, bool, error)
 	RecursiveDel(string) (string, error)
+	//these are false, with no error, when the key exists (Create) or does not have the
+	//value given (CompareAndSwap, CompareAndDelete)
+	Create(string, string, uint64) (bool, error)
+	CompareAndSwap(string, string, string, uint64) (bool, error)
+	CompareAndDelete(string, string) (bool, error)
 }
 
 const (
@@ -23,18 +30,27 @@ type etcdClient struct {
 	client *etcd.Client
 }
 
+//NewEtcdClient returns a connection to the etcd named by ETCD_ENDPOINTS, using TLS if
+//ETCD_CERT_FILE, ETCD_KEY_FILE or ETCD_CA_FILE say so.
 func NewEtcdClient() (EtcdClient, error) {
+	endpoints, config, err := etcdEnvironment()
+	if err != nil {
+		return nil, err
+	}
 	result := &etcdClient{
-		client: etcd.NewClient([]string{constructEctdHost()}),
+		client: etcd.NewClient(endpoints),
+	}
+	if config != nil {
+		result.client.SetTransport(&http.Transport{TLSClientConfig: config})
 	}
-	_, err := result.client.Get("/blah/blah/blah", false, false)
+	flog.Debugf("[etcd] export ETCD_ENDPOINTS='%s'", strings.Join(endpoints, ","))
+	_, err = result.client.Get("/blah/blah/blah", false, false)
 	if err == nil {
 		panic("should not be able to retreive /blah/blah/blah")
 	}
 	/*fmt.Printf("ETCD SENT A RESULT! %v\n", err)*/
-	e := err.(*etcd.EtcdError)
-	if e.ErrorCode != 100 {
-		return nil, e
+	if e, ok := err.(*etcd.EtcdError); !ok || e.ErrorCode != 100 {
+		return nil, fmt.Errorf("etcd at %s (set ETCD_ENDPOINTS): %v", strings.Join(endpoints, ","), err)
 	}
 	return result, nil
 }
@@ -123,3 +139,53 @@ func (e *etcdClient) RecursiveDel(path string) (string, error) {
 	flog.Debugf("[etcd result] %s", resp.PrevNode.Value)
 	return resp.PrevNode.Value, nil
 }
+
+//compareFailed is true for the errors etcd gives when a compare and swap (or create, or
+//compare and delete) does not happen because the key is not what it was expected to be.
+func compareFailed(err error) bool {
+	detail, ok := err.(*etcd.EtcdError)
+	//100 is key not found, 101 compare failed, 105 key already exists
+	return ok && (detail.ErrorCode == 100 || detail.ErrorCode == 101 || detail.ErrorCode == 105)
+}
+
+//Create sets the key to value, with a time to live in seconds, if it does not exist.
+func (e *etcdClient) Create(path string, value string, ttl uint64) (bool, error) {
+	flog.Debugf("[etcd] CREATE %s %s (ttl %d)", path, value, ttl)
+	_, err := e.client.Create(path, value, ttl)
+	if err != nil {
+		flog.Debugf("[etcd err] %v", err)
+		if compareFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//CompareAndSwap sets the key to value, with a time to live in seconds, if it is prev.
+func (e *etcdClient) CompareAndSwap(path string, value string, prev string, ttl uint64) (bool, error) {
+	flog.Debugf("[etcd] CAS %s %s (was %s, ttl %d)", path, value, prev, ttl)
+	_, err := e.client.CompareAndSwap(path, value, ttl, prev, 0)
+	if err != nil {
+		flog.Debugf("[etcd err] %v", err)
+		if compareFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+//CompareAndDelete deletes the key if it is prev.
+func (e *etcdClient) CompareAndDelete(path string, prev string) (bool, error) {
+	flog.Debugf("[etcd] CAD %s (was %s)", path, prev)
+	_, err := e.client.CompareAndDelete(path, prev, 0)
+	if err != nil {
+		flog.Debugf("[etcd err] %v", err)
+		if compareFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -84,3 +84,36 @@ func (_m *MockEtcdClient) RecursiveDel(_param0 string) (string, error) {
 func (_mr *_MockEtcdClientRecorder) RecursiveDel(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "RecursiveDel", arg0)
 }
+
+func (_m *MockEtcdClient) Create(_param0 string, _param1 string, _param2 uint64) (bool, error) {
+	ret := _m.ctrl.Call(_m, "Create", _param0, _param1, _param2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockEtcdClientRecorder) Create(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Create", arg0, arg1, arg2)
+}
+
+func (_m *MockEtcdClient) CompareAndSwap(_param0 string, _param1 string, _param2 string, _param3 uint64) (bool, error) {
+	ret := _m.ctrl.Call(_m, "CompareAndSwap", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockEtcdClientRecorder) CompareAndSwap(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CompareAndSwap", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockEtcdClient) CompareAndDelete(_param0 string, _param1 string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "CompareAndDelete", _param0, _param1)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockEtcdClientRecorder) CompareAndDelete(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CompareAndDelete", arg0, arg1)
+}
@@ -0,0 +1,54 @@
+package io
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"fmt"
+	"io"
+)
+
+//CmdExport writes the artifacts, taken from the source tree or from a container of img
+//like CmdCopy does, as a tarball to out.  Each artifact is placed under its DestinationDir
+//(relative to the root of the tarball), exactly as CmdCopy places them in an image.
+func (d *dockerCli) CmdExport(realPathSource map[string]string, img string, artifacts []*CopyArtifact,
+	out io.Writer) error {
+	_, err := d.artifactTarball(realPathSource, img, artifacts, out)
+	return err
+}
+
+//artifactTarball writes the artifacts as a tarball to out and returns the digest of each,
+//by SourcePath.  A container of img is only created if something is needed from it.
+func (d *dockerCli) artifactTarball(realPathSource map[string]string, img string, artifacts []*CopyArtifact,
+	out io.Writer) (map[string]string, error) {
+	tw := tar.NewWriter(out)
+	digests := make(map[string]string)
+	collect := func(cont string) error {
+		for _, a := range artifacts {
+			h := sha1.New()
+			err := d.artifactEntries(realPathSource, cont, a, func(entry *tar.Header, content io.Reader) error {
+				flog.Debugf("adding %s to the artifacts", entry.Name)
+				if err := tw.WriteHeader(entry); err != nil {
+					return err
+				}
+				_, err := io.Copy(tw, hashEntry(h, entry, content))
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			digests[a.SourcePath] = fmt.Sprintf("%x", h.Sum(nil))
+		}
+		return nil
+	}
+	var err error
+	if len(realPathSource) != len(artifacts) {
+		err = d.withTemporaryContainer(img, collect)
+	} else {
+		flog.Debugln("all artifacts found in source tree, no container needed")
+		err = collect("")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return digests, tw.Close()
+}
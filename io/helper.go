@@ -1,9 +1,16 @@
 package io
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -17,6 +24,12 @@ type Helper interface {
 	ConfigFile() string
 	LastTimeInDirRelative(string) (time.Time, error)
 	LastTimeInDir(string) (time.Time, error)
+	Watch([]string) (Watcher, error)
+	DigestFile(string) (string, error)
+	WriteFileRelative(string, []byte) error
+	UnpackRelative(string, io.Reader) (int, error)
+	DigestArtifact(string, *CopyArtifact) (string, error)
+	GitCommit() (string, error)
 }
 
 // NewHelper creates an implementation of the Helper that runs against
@@ -80,6 +93,20 @@ func (i *helper) LastTimeInDirRelative(relative string) (time.Time, error) {
 	return lastTimeInADirTree(dir, time.Time{})
 }
 
+// DigestFile returns the sha1 of the content of a file, given its full path.
+func (i *helper) DigestFile(fullPath string) (string, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 func (i *helper) LastTimeInDir(fullPath string) (time.Time, error) {
 	return lastTimeInADirTree(fullPath, time.Time{})
 }
@@ -127,3 +154,195 @@ func contains(items []string, item string) bool {
 	}
 	return false
 }
+
+// WriteFileRelative writes content to a file given relative to the pickett config file,
+// creating the directories needed.
+func (i *helper) WriteFileRelative(path string, content []byte) error {
+	full := i.DirectoryRelative(path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(full, content, 0644)
+}
+
+// GitCommit returns the commit checked out in the directory of the pickett config file,
+// with a "+" if there are changes that are not committed.
+func (i *helper) GitCommit() (string, error) {
+	out, err := exec.Command("git", "-C", i.pickettDir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git checkout: %v", i.pickettDir, err)
+	}
+	commit := strings.TrimSpace(string(out))
+	status, err := exec.Command("git", "-C", i.pickettDir, "status", "--porcelain", "--untracked-files=no").Output()
+	if err == nil && len(bytes.TrimSpace(status)) > 0 {
+		commit += "+"
+	}
+	return commit, nil
+}
+
+// UnpackRelative writes the files, directories and links of a tarball into a directory given
+// relative to the pickett config file.  Files and links that are already there with the same
+// content and mode (or target) are left alone, so their modification times don't change.
+// Nothing is written outside of the directory, through links or otherwise, and entries other
+// than files, directories and links are refused.  It returns the number of entries written.
+func (i *helper) UnpackRelative(dir string, r io.Reader) (int, error) {
+	root := filepath.Clean(i.DirectoryRelative(dir))
+	written := 0
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		full := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if !inside(root, full) || full == root && hdr.Typeflag != tar.TypeDir {
+			return written, fmt.Errorf("refusing to write %s outside of %s", hdr.Name, root)
+		}
+		if err := noLinksBetween(root, filepath.Dir(full)); err != nil {
+			return written, err
+		}
+		var changed bool
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(full, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			changed, err = unpackFile(full, hdr, tr)
+		case tar.TypeSymlink:
+			changed, err = unpackSymlink(root, full, hdr)
+		case tar.TypeLink:
+			changed, err = unpackHardlink(root, full, hdr)
+		default:
+			err = fmt.Errorf("refusing to unpack %s, it is not a file, directory or link", hdr.Name)
+		}
+		if err != nil {
+			return written, err
+		}
+		if changed {
+			written++
+		}
+	}
+}
+
+// inside is true if path is root or in it.
+func inside(root string, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+// noLinksBetween checks that none of the directories from root down to dir are links, which
+// an earlier entry of a tarball could have made to write elsewhere.  The ones that are not
+// there yet are made.
+func noLinksBetween(root string, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+	path := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return os.MkdirAll(dir, 0755)
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("refusing to write in %s, it is not a directory", path)
+		}
+	}
+	return nil
+}
+
+// makeWay makes way for an entry to be written at full.  A file or link there is removed, so
+// it is replaced rather than written through, anything else, like a directory, is an error.
+func makeWay(full string) error {
+	info, err := os.Lstat(full)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() && info.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("refusing to replace %s, it is not a file or link", full)
+	}
+	return os.Remove(full)
+}
+
+// unpackFile writes the file hdr describes to full, unless it is there already.  A link at
+// full is replaced, not followed.
+func unpackFile(full string, hdr *tar.Header, content io.Reader) (bool, error) {
+	buf, err := ioutil.ReadAll(content)
+	if err != nil {
+		return false, err
+	}
+	mode := hdr.FileInfo().Mode().Perm()
+	if info, err := os.Lstat(full); err == nil && info.Mode().IsRegular() && info.Mode().Perm() == mode {
+		if old, err := ioutil.ReadFile(full); err == nil && bytes.Equal(old, buf) {
+			return false, nil
+		}
+	}
+	if err := makeWay(full); err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	//the umask applies to new files
+	return true, os.Chmod(full, mode)
+}
+
+// unpackSymlink makes the symbolic link hdr describes at full.  Its target must be relative
+// and in root.
+func unpackSymlink(root string, full string, hdr *tar.Header) (bool, error) {
+	if filepath.IsAbs(hdr.Linkname) || !inside(root, filepath.Join(filepath.Dir(full), hdr.Linkname)) {
+		return false, fmt.Errorf("refusing to link %s to %s, outside of %s", hdr.Name, hdr.Linkname, root)
+	}
+	if target, err := os.Readlink(full); err == nil && target == hdr.Linkname {
+		return false, nil
+	}
+	if err := makeWay(full); err != nil {
+		return false, err
+	}
+	return true, os.Symlink(hdr.Linkname, full)
+}
+
+// unpackHardlink makes the hard link hdr describes at full, to a file unpacked earlier.
+func unpackHardlink(root string, full string, hdr *tar.Header) (bool, error) {
+	target := filepath.Join(root, filepath.FromSlash(hdr.Linkname))
+	if !inside(root, target) {
+		return false, fmt.Errorf("refusing to link %s to %s, outside of %s", hdr.Name, hdr.Linkname, root)
+	}
+	if err := noLinksBetween(root, filepath.Dir(target)); err != nil {
+		return false, err
+	}
+	info, err := os.Lstat(target)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, fmt.Errorf("refusing to link %s to %s, it is not a file that was unpacked", hdr.Name, hdr.Linkname)
+	}
+	if there, err := os.Lstat(full); err == nil && os.SameFile(info, there) {
+		return false, nil
+	}
+	if err := makeWay(full); err != nil {
+		return false, err
+	}
+	return true, os.Link(target, full)
+}
+
+// DigestArtifact returns the digest of an artifact that is in the source tree, given the
+// full path of its SourcePath on this machine.  It is the digest CmdCopy labels the image
+// with.
+func (i *helper) DigestArtifact(hostPath string, a *CopyArtifact) (string, error) {
+	return digestArtifact(hostPath, a)
+}
@@ -104,3 +104,68 @@ func (_m *MockHelper) LastTimeInDir(_param0 string) (time.Time, error) {
 func (_mr *_MockHelperRecorder) LastTimeInDir(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "LastTimeInDir", arg0)
 }
+
+func (_m *MockHelper) Watch(_param0 []string) (Watcher, error) {
+	ret := _m.ctrl.Call(_m, "Watch", _param0)
+	ret0, _ := ret[0].(Watcher)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) Watch(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Watch", arg0)
+}
+
+func (_m *MockHelper) DigestFile(_param0 string) (string, error) {
+	ret := _m.ctrl.Call(_m, "DigestFile", _param0)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) DigestFile(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DigestFile", arg0)
+}
+
+func (_m *MockHelper) WriteFileRelative(_param0 string, _param1 []byte) error {
+	ret := _m.ctrl.Call(_m, "WriteFileRelative", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockHelperRecorder) WriteFileRelative(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "WriteFileRelative", arg0, arg1)
+}
+
+func (_m *MockHelper) GitCommit() (string, error) {
+	ret := _m.ctrl.Call(_m, "GitCommit")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) GitCommit() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GitCommit")
+}
+
+func (_m *MockHelper) UnpackRelative(_param0 string, _param1 io.Reader) (int, error) {
+	ret := _m.ctrl.Call(_m, "UnpackRelative", _param0, _param1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) UnpackRelative(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnpackRelative", arg0, arg1)
+}
+
+func (_m *MockHelper) DigestArtifact(_param0 string, _param1 *CopyArtifact) (string, error) {
+	ret := _m.ctrl.Call(_m, "DigestArtifact", _param0, _param1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockHelperRecorder) DigestArtifact(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DigestArtifact", arg0, arg1)
+}
@@ -0,0 +1,98 @@
+package io
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+//EndpointAddress returns the IP address containers on other hosts can reach the docker host
+//at endpoint on, for linking to the ports published there.  For a unix socket that is the
+//first address of this machine that is not a loopback.
+func EndpointAddress(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "unix" {
+		return localAddress()
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", fmt.Errorf("can't find the host of %s: %v", endpoint, err)
+	}
+	return ResolveAddress(host)
+}
+
+//ResolveAddress returns host as an IP address, looking it up if it is a name.
+func ResolveAddress(host string) (string, error) {
+	if host == "" {
+		return localAddress()
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no address for %s", host)
+	}
+	return ips[0].String(), nil
+}
+
+//localAddress returns the first IPv4 address of this machine that is not a loopback.
+func localAddress() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no address other than loopback on this machine")
+}
+
+//hostConfigWithExtraHosts is a host config with the ExtraHosts the vendored client does not
+//know about.
+type hostConfigWithExtraHosts struct {
+	docker.HostConfig
+	ExtraHosts []string
+}
+
+//startWithExtraHosts starts a container the way StartContainer does, but with entries added
+//to its /etc/hosts.
+func (d *dockerCli) startWithExtraHosts(id string, host *docker.HostConfig, extra []string) error {
+	_, err := d.raw.call("POST", "/containers/"+id+"/start", &hostConfigWithExtraHosts{*host, extra})
+	return err
+}
+
+//SaveImage returns a tarball of the image name, with its tags and history, like docker save.
+func (d *dockerCli) SaveImage(name string) (io.ReadCloser, error) {
+	flog.Debugf("[docker cmd] docker save %s", name)
+	return d.raw.stream("GET", "/images/"+name+"/get", "", nil)
+}
+
+//LoadImage loads a tarball made by SaveImage, like docker load.
+func (d *dockerCli) LoadImage(tarball io.Reader) error {
+	flog.Debugf("[docker cmd] docker load")
+	resp, err := d.raw.stream("POST", "/images/load", "application/x-tar", tarball)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	_, err = io.Copy(ioutil.Discard, resp)
+	return err
+}
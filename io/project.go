@@ -0,0 +1,62 @@
+package io
+
+import (
+	"net/url"
+)
+
+//PROJECT_LABEL is the project (the Pickett.json) the containers and images pickett
+//creates belong to, so a project can clean up after itself without touching the others.
+const PROJECT_LABEL = "pickett.project"
+
+//ImageInfo describes an image in a list of images.
+type ImageInfo struct {
+	ID       string `json:"Id"`
+	RepoTags []string
+	Labels   map[string]string
+}
+
+//projectFilter is the filter for the things that belong to project.
+func projectFilter(project string) string {
+	return url.QueryEscape(`{"label":["` + PROJECT_LABEL + `=` + project + `"]}`)
+}
+
+//ListProjectContainers returns the containers of project, running or not.  Containers
+//without the label are never returned, even by a docker that does not filter on it.
+func (d *dockerCli) ListProjectContainers(project string) ([]*ContainerInfo, error) {
+	all := []*ContainerInfo{}
+	if err := d.raw.callJSON("GET", "/containers/json?all=1&filters="+projectFilter(project), nil, &all); err != nil {
+		return nil, err
+	}
+	result := []*ContainerInfo{}
+	for _, c := range all {
+		if c.Labels[PROJECT_LABEL] == project {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+//ListProjectImages returns the images built for project.  Images without the label are
+//never returned, even by a docker that does not filter on it.
+func (d *dockerCli) ListProjectImages(project string) ([]*ImageInfo, error) {
+	all := []*ImageInfo{}
+	if err := d.raw.callJSON("GET", "/images/json?filters="+projectFilter(project), nil, &all); err != nil {
+		return nil, err
+	}
+	result := []*ImageInfo{}
+	for _, img := range all {
+		if img.Labels[PROJECT_LABEL] == project {
+			result = append(result, img)
+		}
+	}
+	return result, nil
+}
+
+//ListAllContainers returns every container docker has, running or not, with its labels.
+func (d *dockerCli) ListAllContainers() ([]*ContainerInfo, error) {
+	result := []*ContainerInfo{}
+	if err := d.raw.callJSON("GET", "/containers/json?all=1", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,179 @@
+package io
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+//rawClient talks to the docker remote API directly, for the parts of the API that the
+//vendored client does not know about (exec, for example).  Errors are reported as
+//*docker.Error so callers can treat them the same way as errors from the client.
+type rawClient struct {
+	base string
+	http *http.Client
+}
+
+//newRawClient builds a rawClient for a DOCKER_HOST style endpoint, using config for https.
+func newRawClient(endpoint string, config *tls.Config) (*rawClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		sock := u.Path
+		return &rawClient{
+			base: "http://docker",
+			http: &http.Client{
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return net.Dial("unix", sock)
+					},
+				},
+			},
+		}, nil
+	case "tcp", "http":
+		return &rawClient{base: "http://" + u.Host, http: http.DefaultClient}, nil
+	case "https":
+		client := http.DefaultClient
+		if config != nil {
+			client = &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		}
+		return &rawClient{base: "https://" + u.Host, http: client}, nil
+	}
+	return nil, fmt.Errorf("don't know how to talk to docker at %s", endpoint)
+}
+
+//call sends body (if any) as json and returns the body of the response.
+func (r *rawClient) call(method string, path string, body interface{}) ([]byte, error) {
+	var in io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		in = bytes.NewBuffer(buf)
+	}
+	req, err := http.NewRequest(method, r.base+path, in)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return nil, &docker.Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(result))}
+	}
+	return result, nil
+}
+
+//stream sends in as the body, with the content type given, and returns the body of the
+//response for the caller to read as it arrives.  The caller closes it.
+func (r *rawClient) stream(method string, path string, contentType string, in io.Reader) (io.ReadCloser, error) {
+	req, err := http.NewRequest(method, r.base+path, in)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		result, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &docker.Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(result))}
+	}
+	return resp.Body, nil
+}
+
+//callJSON is call with the response decoded into result.
+func (r *rawClient) callJSON(method string, path string, body interface{}, result interface{}) error {
+	raw, err := r.call(method, path, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, result)
+}
+
+//attach gets the output of a container, and if stream is set follows it until the
+//container exits, writing it to stdout and stderr.
+func (r *rawClient) attach(id string, stream bool, stdout io.Writer, stderr io.Writer) error {
+	params := url.Values{}
+	params.Set("logs", "1")
+	params.Set("stdout", "1")
+	params.Set("stderr", "1")
+	if stream {
+		params.Set("stream", "1")
+	}
+	body, err := r.stream("POST", "/containers/"+id+"/attach?"+params.Encode(), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return demuxStream(body, stdout, stderr)
+}
+
+//demuxStream is demux for a stream that is read as it arrives, with stdout and stderr kept
+//apart.
+func demuxStream(in io.Reader, stdout io.Writer, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(in, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("short header in docker stream: %v", err)
+		}
+		out := stdout
+		if header[0] == 2 {
+			out = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:8]))
+		if _, err := io.CopyN(out, in, size); err != nil {
+			return fmt.Errorf("short frame in docker stream: %v", err)
+		}
+	}
+}
+
+//demux splits a multiplexed stdout/stderr stream (as produced by attach and exec when
+//there is no tty) and writes both streams to out.
+func demux(raw []byte, out io.Writer) error {
+	for len(raw) > 0 {
+		if len(raw) < 8 {
+			return fmt.Errorf("short header in docker stream (%d bytes)", len(raw))
+		}
+		size := int(binary.BigEndian.Uint32(raw[4:8]))
+		raw = raw[8:]
+		if size > len(raw) {
+			return fmt.Errorf("short frame in docker stream (%d of %d bytes)", len(raw), size)
+		}
+		if _, err := out.Write(raw[:size]); err != nil {
+			return err
+		}
+		raw = raw[size:]
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package io
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+//OWNED_LABEL marks the images and containers pickett creates, so that cleaning up never
+//touches anything else.  Images built from a container pickett created inherit it.
+const OWNED_LABEL = "pickett.owned"
+
+//TEMPORARY_LABEL marks the containers pickett only needs while it runs: the ones it copies
+//artifacts out of and the ones it builds in.  They are removed when pickett is done with
+//them, and "pickett gc" removes any that are left over because pickett was interrupted.
+const (
+	TEMPORARY_LABEL = "pickett.temporary"
+	NOT_TEMPORARY   = "no"
+)
+
+//ContainerInfo describes a container in a list of containers.
+type ContainerInfo struct {
+	ID      string `json:"Id"`
+	Names   []string
+	Image   string
+	Status  string
+	Created int64
+	Labels  map[string]string
+}
+
+//Running is true if the container is up, going by its status.
+func (c *ContainerInfo) Running() bool {
+	return strings.HasPrefix(c.Status, "Up")
+}
+
+//createContainer creates a container with labels, which the vendored client does not
+//know about.  The container is labelled as pickett's too.
+func (d *dockerCli) createContainer(opts docker.CreateContainerOptions, labels map[string]string) (*docker.Container, error) {
+	all := map[string]string{OWNED_LABEL: "true"}
+	for k, v := range labels {
+		all[k] = v
+	}
+	body := struct {
+		*docker.Config
+		Labels map[string]string `json:",omitempty"`
+	}{opts.Config, all}
+	path := "/containers/create"
+	if opts.Name != "" {
+		path += "?name=" + url.QueryEscape(opts.Name)
+	}
+	var result struct {
+		Id string
+	}
+	if err := d.raw.callJSON("POST", path, body, &result); err != nil {
+		return nil, err
+	}
+	return &docker.Container{ID: result.Id, Name: opts.Name}, nil
+}
+
+//withTemporaryContainer calls fn with a started container of img, which runs nothing
+//useful: it's there to copy things out of.  The container is removed when fn returns,
+//whatever happens.
+func (d *dockerCli) withTemporaryContainer(img string, fn func(string) error) error {
+	opts := docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      img,
+			Entrypoint: []string{"/bin/true"},
+		},
+	}
+	cont, err := d.createContainer(opts, map[string]string{TEMPORARY_LABEL: "copy"})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flog.Debugf("removing temporary container %s of %s", cont.ID, img)
+		if err := d.client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID, Force: true}); err != nil {
+			flog.Warningf("unable to remove temporary container %s of %s: %v", cont.ID, img, err)
+		}
+	}()
+	if err := d.client.StartContainer(cont.ID, &docker.HostConfig{}); err != nil {
+		return err
+	}
+	return fn(cont.ID)
+}
+
+//ListTemporaryContainers returns the temporary containers of project, running or not.
+//Containers without the labels are never returned, even by a docker that does not filter
+//on them.
+func (d *dockerCli) ListTemporaryContainers(project string) ([]*ContainerInfo, error) {
+	labels := `"` + TEMPORARY_LABEL + `"`
+	if project != "" {
+		labels += `,"` + PROJECT_LABEL + `=` + project + `"`
+	}
+	filters := url.QueryEscape(`{"label":[` + labels + `]}`)
+	all := []*ContainerInfo{}
+	if err := d.raw.callJSON("GET", "/containers/json?all=1&filters="+filters, nil, &all); err != nil {
+		return nil, err
+	}
+	result := []*ContainerInfo{}
+	for _, c := range all {
+		temporary, ok := c.Labels[TEMPORARY_LABEL]
+		if !ok || temporary == NOT_TEMPORARY || project != "" && c.Labels[PROJECT_LABEL] != project {
+			continue
+		}
+		result = append(result, c)
+	}
+	return result, nil
+}
+
+//ListDanglingImages returns the IDs of the images pickett built for project that have no
+//tag and are not the parent of another image, the leftovers of builds.  Images without
+//pickett's labels are never returned, even by a docker that does not filter on them.
+func (d *dockerCli) ListDanglingImages(project string) ([]string, error) {
+	labels := `"` + OWNED_LABEL + `"`
+	if project != "" {
+		labels += `,"` + PROJECT_LABEL + `=` + project + `"`
+	}
+	filters := url.QueryEscape(`{"dangling":["true"],"label":[` + labels + `]}`)
+	var images []*ImageInfo
+	if err := d.raw.callJSON("GET", "/images/json?filters="+filters, nil, &images); err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, img := range images {
+		if img.Labels[OWNED_LABEL] != "true" || project != "" && img.Labels[PROJECT_LABEL] != project {
+			flog.Debugf("not counting image %s as dangling, it is not one of ours", img.ID)
+			continue
+		}
+		result = append(result, img.ID)
+	}
+	return result, nil
+}
+
+//ImageLabels returns the labels of an image, which the vendored client does not know
+//about.
+func (d *dockerCli) ImageLabels(name string) (map[string]string, error) {
+	var result struct {
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := d.raw.callJSON("GET", "/images/"+name+"/json", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Config.Labels, nil
+}
+
+//containerLabels returns the labels of a container, which the vendored client does not
+//know about.  The result is never nil.
+func (d *dockerCli) containerLabels(id string) (map[string]string, error) {
+	var result struct {
+		Config struct {
+			Labels map[string]string
+		}
+	}
+	if err := d.raw.callJSON("GET", "/containers/"+id+"/json", nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Config.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return result.Config.Labels, nil
+}
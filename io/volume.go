@@ -0,0 +1,43 @@
+package io
+
+import (
+	"net/url"
+)
+
+//VolumeInfo describes a docker volume.
+type VolumeInfo struct {
+	Name       string
+	Mountpoint string
+	Labels     map[string]string
+}
+
+//CmdCreateVolume creates a named volume with the labels given.  Creating a volume that
+//already exists is not an error, docker returns the existing one.
+func (d *dockerCli) CmdCreateVolume(name string, labels map[string]string) error {
+	flog.Debugf("[docker cmd] creating volume %s %v", name, labels)
+	body := map[string]interface{}{
+		"Name":   name,
+		"Labels": labels,
+	}
+	_, err := d.raw.call("POST", "/volumes/create", body)
+	return err
+}
+
+//CmdListVolumes returns the volumes that have the label given (with any value).
+func (d *dockerCli) CmdListVolumes(label string) ([]*VolumeInfo, error) {
+	filters := url.QueryEscape(`{"label":["` + label + `"]}`)
+	var result struct {
+		Volumes []*VolumeInfo
+	}
+	if err := d.raw.callJSON("GET", "/volumes?filters="+filters, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Volumes, nil
+}
+
+//CmdRmVolume removes a volume.  It fails if the volume is in use by a container.
+func (d *dockerCli) CmdRmVolume(name string) error {
+	flog.Debugf("[docker cmd] removing volume %s", name)
+	_, err := d.raw.call("DELETE", "/volumes/"+url.QueryEscape(name), nil)
+	return err
+}
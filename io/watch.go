@@ -0,0 +1,15 @@
+package io
+
+//Watcher reports changes to the files in a set of directory trees.  Each value received
+//from Changes is the path of something that was created, written, removed or renamed.
+//The channel is closed when the watcher stops, either because of Close or an error.
+type Watcher interface {
+	Changes() <-chan string
+	Close() error
+}
+
+// Watch returns a Watcher for the directory trees given, which should be full paths.
+// Directories created in the trees later are watched too.
+func (i *helper) Watch(dirs []string) (Watcher, error) {
+	return newWatcher(dirs)
+}
@@ -0,0 +1,140 @@
+package io
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+//inotifyWatcher watches every directory in the trees it is given with inotify.
+type inotifyWatcher struct {
+	fd      int
+	roots   []string
+	lock    sync.Mutex
+	dirs    map[int]string //watch descriptor to directory
+	closed  bool
+	changes chan string
+}
+
+func newWatcher(roots []string) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("can't start inotify: %v", err)
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		roots:   roots,
+		dirs:    make(map[int]string),
+		changes: make(chan string, 64),
+	}
+	for _, root := range roots {
+		if err := w.addTree(root); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+//addTree adds a watch on root and every directory below it.
+func (w *inotifyWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return fmt.Errorf("can't watch %s: %v", path, err)
+		}
+		w.lock.Lock()
+		w.dirs[wd] = path
+		w.lock.Unlock()
+		return nil
+	})
+}
+
+//loop reads events until the watcher is closed.
+func (w *inotifyWatcher) loop() {
+	defer close(w.changes)
+	defer syscall.Close(w.fd)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(w.fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			flog.Errorf("stopped watching for changes: %v", err)
+			return
+		}
+		w.lock.Lock()
+		closed := w.closed
+		w.lock.Unlock()
+		if closed {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[start:start+int(ev.Len)]), "\x00")
+			offset = start + int(ev.Len)
+			w.handle(ev, name)
+		}
+	}
+}
+
+//handle reports one event and starts watching directories that appear.
+func (w *inotifyWatcher) handle(ev *syscall.InotifyEvent, name string) {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		//we lost events, so anything could have changed
+		for _, root := range w.roots {
+			w.changes <- root
+		}
+		return
+	}
+	w.lock.Lock()
+	dir, ok := w.dirs[int(ev.Wd)]
+	if ev.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, int(ev.Wd))
+	}
+	w.lock.Unlock()
+	if !ok || ev.Mask&syscall.IN_IGNORED != 0 {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.addTree(path); err != nil {
+			flog.Warningf("not watching new directory: %v", err)
+		}
+	}
+	w.changes <- path
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+//Close removes all the watches, which wakes up the loop so it can exit.
+func (w *inotifyWatcher) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	for wd := range w.dirs {
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+	}
+	return nil
+}
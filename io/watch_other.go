@@ -0,0 +1,71 @@
+// +build !linux
+
+package io
+
+import (
+	"sync"
+	"time"
+)
+
+const pollInterval = time.Second
+
+//pollWatcher is used where there is no inotify.  It compares the latest modification time
+//in each tree every second, so it reports the tree that changed rather than the file.
+type pollWatcher struct {
+	roots   []string
+	lock    sync.Mutex
+	closed  bool
+	changes chan string
+}
+
+func newWatcher(roots []string) (Watcher, error) {
+	w := &pollWatcher{
+		roots:   roots,
+		changes: make(chan string, 64),
+	}
+	last := make(map[string]time.Time)
+	for _, root := range roots {
+		t, err := lastTimeInADirTree(root, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		last[root] = t
+	}
+	go w.loop(last)
+	return w, nil
+}
+
+func (w *pollWatcher) loop(last map[string]time.Time) {
+	defer close(w.changes)
+	for {
+		time.Sleep(pollInterval)
+		w.lock.Lock()
+		closed := w.closed
+		w.lock.Unlock()
+		if closed {
+			return
+		}
+		for _, root := range w.roots {
+			t, err := lastTimeInADirTree(root, time.Time{})
+			if err != nil {
+				flog.Errorf("stopped watching for changes: %v", err)
+				return
+			}
+			if t.After(last[root]) {
+				last[root] = t
+				w.changes <- root
+			}
+		}
+	}
+}
+
+func (w *pollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	return nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/igneous-systems/logit"
 	"gopkg.in/alecthomas/kingpin.v1"
@@ -23,23 +26,32 @@ var (
 	// Global flags
 	debug      = app.Flag("debug", "Enable debug mode.").Short('d').Bool()
 	configFile = app.Flag("configFile", "Config file.").Short('f').Default("Pickett.json").String()
+	project    = app.Flag("project", "Work on this project instead of the one of the config file.").String()
+	quietBuild = app.Flag("quiet-builds", "Only show the output of builds that fail.").Short('q').Bool()
 
 	// Actions
-	run     = app.Command("run", "Runs a specific node in a topology, including all depedencies.")
-	runTopo = run.Arg("topo", "Topo node.").Required().String()
+	run     = app.Command("run", "Runs a specific node in a topology, including all depedencies, or a whole topology.")
+	runTopo = run.Arg("topo", "Topology or topology node.").Required().String()
 	runVol  = run.Flag("runvol", "runvolume like /foo:/bar/foo").Short('r').String()
 
+	watch         = app.Command("watch", "Runs a topology or topology node, then rebuilds and restarts it when its source changes.")
+	watchTopo     = watch.Arg("topo", "Topology or topology node.").Required().String()
+	watchVol      = watch.Flag("runvol", "runvolume like /foo:/bar/foo").Short('r').String()
+	watchDebounce = watch.Flag("debounce", "Milliseconds without changes before acting on them.").Default("500").Int()
+
 	status        = app.Command("status", "Shows the status of all the known buildable tags and/or runnable nodes.")
 	statusTargets = status.Arg("targets", "Tags / Nodes").Strings()
 
 	build     = app.Command("build", "Build all tags or specified tags.")
 	buildTags = build.Arg("tags", "Tags").Strings()
 
-	stop      = app.Command("stop", "Stop all or a specific node.")
-	stopNodes = stop.Arg("topology.nodes", "Topology Nodes").Strings()
+	stop        = app.Command("stop", "Stop all or specific nodes or topologies, in reverse dependency order.")
+	stopNodes   = stop.Arg("topology.nodes", "Topologies or Topology Nodes").Strings()
+	stopTimeout = stop.Flag("timeout", "Seconds to wait before killing, overrides StopTimeout.").Short('t').Default("-1").Int()
 
-	drop      = app.Command("drop", "Stop and delete all or specific node.")
-	dropNodes = drop.Arg("topology.nodes", "Topology Nodes").Strings()
+	drop        = app.Command("drop", "Stop and delete all or specific nodes or topologies, in reverse dependency order.")
+	dropNodes   = drop.Arg("topology.nodes", "Topologies or Topology Nodes").Strings()
+	dropTimeout = drop.Flag("timeout", "Seconds to wait before killing, overrides StopTimeout.").Short('t').Default("-1").Int()
 
 	wipe     = app.Command("wipe", "Delete all or specified tag (force rebuild next time).")
 	wipeTags = wipe.Arg("tags", "Tags").Strings()
@@ -47,6 +59,20 @@ var (
 	ps      = app.Command("ps", "Give 'docker ps' like output of running topologies.")
 	psNodes = ps.Arg("topology.nodes", "Topology Nodes").Strings()
 
+	cont      = app.Command("continue", "Show or reset the state accumulated by nodes with the CONTINUE policy.")
+	contNodes = cont.Arg("topology.nodes", "Topologies or Topology Nodes").Strings()
+	contReset = cont.Flag("reset", "Throw away the accumulated state, the next run starts fresh.").Bool()
+
+	cache       = app.Command("cache", "List (ls) or remove (prune) the volumes that hold GoBuild caches.")
+	cacheAction = cache.Arg("action", "ls or prune").Required().String()
+	cacheNames  = cache.Arg("caches", "Caches to prune (all if none)").Strings()
+
+	history    = app.Command("history", "Show who built a tag, when, from what commit and how long it took.")
+	historyTag = history.Arg("tag", "Tag, like repo:tag").Required().String()
+
+	gc     = app.Command("gc", "Remove temporary containers, untagged images and old images pickett built.")
+	gcKeep = gc.Flag("keep", "Old images to keep of each tag, overrides Keep.").Default("-1").Int()
+
 	inject     = app.Command("inject", "Run the given command in the given topology node")
 	injectNode = inject.Arg("topology.node", "Topology Node").Required().String()
 	injectCmd  = inject.Arg("Cmd", "Node").Required().Strings()
@@ -57,9 +83,20 @@ var (
 	etcdSetKey = etcdSet.Arg("key", "Etcd key (full path)").Required().String()
 	etcdSetVal = etcdSet.Arg("value", "Etcd value").Required().String()
 
-	destroy = app.Command("destroy", "Remove all containers and images, wipe etcd")
+	reconcile = app.Command("reconcile", "Make etcd agree with docker: forget containers that are gone and adopt ones it lost.")
+
+	destroy            = app.Command("destroy", "Remove the containers and images of this project and wipe its state in etcd.")
+	destroyAllProjects = destroy.Flag("all-projects", "Remove ALL containers and images on the docker server and wipe etcd.").Bool()
+	destroyYes         = destroy.Flag("yes", "Don't ask before destroying all projects.").Bool()
 )
 
+//confirm asks the user to type yes to go ahead with what question describes.
+func confirm(question string) bool {
+	fmt.Printf("[pickett] %s\n[pickett] type yes to go ahead: ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
 func contains(s []string, target string) bool {
 	for _, candidate := range s {
 		if candidate == target {
@@ -122,11 +159,6 @@ func wrappedMain() int {
 	logit.Global.ModifyFilterLvl("stdout", logFilterLvl, nil, nil)
 	defer logit.Flush(-1)
 
-	if os.Getenv("DOCKER_HOST") == "" {
-		fmt.Fprintf(os.Stderr, "DOCKER_HOST not set; suggest DOCKER_HOST=tcp://:2375 (for local launcher)\n")
-		return 1
-	}
-
 	_, err := os.Open(*configFile)
 	if err != nil {
 		wd, _ := os.Getwd()
@@ -151,23 +183,54 @@ func wrappedMain() int {
 		flog.Errorf("Can't understand config file %s: %v", err.Error(), helper.ConfigFile())
 		return 1
 	}
+	if err := config.SetProject(*project, absconf); err != nil {
+		flog.Errorf("%v", err)
+		return 1
+	}
+	if err := config.AdoptLegacyState(); err != nil {
+		flog.Errorf("%v", err)
+		return 1
+	}
+	if err := config.ConnectHosts(io.NewDockerCliFor); err != nil {
+		flog.Errorf("%v", err)
+		return 1
+	}
+	if *quietBuild {
+		config.DockerBuildOptions.Quiet = true
+	}
+	//commands that look at the instances of topologies should not trip over containers
+	//that were removed behind our back
+	switch action {
+	case "run", "watch", "status", "stop", "drop", "ps", "continue", "inject":
+		config.LightReconcile()
+	}
 
 	returnCode := 0
 	switch action {
 	case "run":
 		returnCode, err = pickett.CmdRun(*runTopo, *runVol, config)
+	case "watch":
+		err = pickett.CmdWatch(*watchTopo, *watchVol, time.Duration(*watchDebounce)*time.Millisecond, config)
     case "build":
 		err = pickett.CmdBuild(*buildTags, config)
 	case "status":
 		err = pickett.CmdStatus(*statusTargets, config)
 	case "stop":
-		err = pickett.CmdStop(*stopNodes, config)
+		err = pickett.CmdStop(*stopNodes, *stopTimeout, config)
 	case "drop":
-		err = pickett.CmdDrop(*dropNodes, config)
+		err = pickett.CmdDrop(*dropNodes, *dropTimeout, config)
 	case "wipe":
 		err = pickett.CmdWipe(*wipeTags, config)
 	case "ps":
 		err = pickett.CmdPs(*psNodes, config)
+	case "continue":
+		err = pickett.CmdContinue(*contNodes, *contReset, config)
+	case "cache":
+		err = pickett.CmdCache(*cacheAction, *cacheNames, config)
+	case "gc":
+		err = pickett.CmdGC(*gcKeep, config)
+	case "history":
+		err = pickett.CmdHistory(*historyTag, config)
 	case "inject":
 		err = pickett.CmdInject(*injectNode, *injectCmd, config)
 	case "etcdget":
@@ -183,13 +246,21 @@ func wrappedMain() int {
 			fmt.Print(err)
 			return 1
 		}
+	case "reconcile":
+		err = pickett.CmdReconcile(config)
 	case "destroy":
-		err = pickett.CmdDestroy(config)
+		if *destroyAllProjects && !*destroyYes && !confirm(
+			"This removes ALL containers and images on the docker server and wipes etcd, for every project.") {
+			fmt.Println("[pickett] not destroying anything")
+			return 1
+		}
+		err = pickett.CmdDestroy(*destroyAllProjects, config)
 	default:
 		app.Usage(os.Stderr)
 		return 1
 	}
 
+	config.PrintTimings()
 	if err != nil {
 		flog.Errorf("%s: %v", action, err)
 		return 1